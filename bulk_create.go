@@ -7,7 +7,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"text/template"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jeffbean/inam/phab"
 
@@ -31,35 +34,55 @@ type ManiphestCreateTaskRequest struct {
 	Title        string   `json:"title"`
 	Description  string   `json:"description"`
 	OwnerPHID    string   `json:"ownerPHID"`
-	ViewPolicy   string   `json:"viewPolicy"`
-	EditPolicy   string   `json:"editPolicy"`
+	ViewPolicy   string   `json:"viewPolicy,omitempty"`
+	EditPolicy   string   `json:"editPolicy,omitempty"`
 	CCPHIDs      []string `json:"ccPHIDs"`
-	Priority     int      `json:"priority"`
+	Priority     *int     `json:"priority,omitempty"`
 	ProjectPHIDs []string `json:"projectPHIDs"`
-	Auxiliary    Aux      `json:"auxiliary"`
+	// Auxiliary holds the std:maniphest:* and custom:* fields of the task.
+	Auxiliary map[string]interface{} `json:"auxiliary"`
 	requests.Request
 }
 
-type Aux struct {
-	Type string `json:"std:maniphest:task_type"`
-}
-
 type yamlConfig struct {
 	TaskTemplate  string `yaml:"taskTemplate"`
 	TitleTemplate string `yaml:"titleTemplate"`
+	// the template files and partials dir are relative to the config
+	TaskTemplateFile  string `yaml:"taskTemplateFile,omitempty"`
+	TitleTemplateFile string `yaml:"titleTemplateFile,omitempty"`
+	TemplateDir       string `yaml:"templateDir,omitempty"`
+	// Roster is a csv or json lines file of more entries, relative to the config.
+	// Columns besides owner, projects and ccUsers have to be in DefaultVars.
+	Roster string `yaml:"roster,omitempty"`
 
 	CommonProjects []string `yaml:"commonProjects" `
 	CommonCCUsers  []string `yaml:"commonCCUsers" `
+	// the task fields every entry gets unless it sets its own
+	taskFields `yaml:",inline"`
+	// DefaultVars are the template variables of every entry, entries can override them.
+	DefaultVars map[string]interface{} `yaml:"defaultVars,omitempty"`
+	// Umbrella is created first for all the entries to become subtasks of.
+	Umbrella *umbrellaConfig `yaml:"umbrella,omitempty"`
 
 	Emails []emailConfig `yaml:"emails"`
 }
 
 type emailConfig struct {
-	Owner    string   `yaml:""`
+	Owner string `yaml:""`
+	// OwnersOf and Owners make the entry a group, one task is created for each
+	// member of the projects and each of the users except the excluded ones.
+	OwnersOf []string `yaml:"ownersOf,omitempty"`
+	Owners   []string `yaml:"owners,omitempty"`
+	Exclude  []string `yaml:"exclude,omitempty"`
+
 	CCUsers  []string `yaml:",omitempty"`
 	Projects []string `yaml:",omitempty"`
 	// InsertHere is aninterface you can then use in the template as you please
 	InsertHere string `yaml:"insertHere,omitempty"`
+	// Vars are the template variables of the entry available as {{ .Vars.name }}.
+	Vars map[string]interface{} `yaml:"vars,omitempty"`
+
+	taskFields `yaml:",inline"`
 }
 
 type phabBulkCreateCommand struct {
@@ -69,9 +92,19 @@ type phabBulkCreateCommand struct {
 	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
 
 	EmailConfig string `long:"email-config" description:"The yaml file configuring the bulk emails"`
+	Roster      string `long:"roster" description:"A csv or json lines file of entries added to the ones of the config, overrides the roster of the config"`
 
 	ActuallyCreate bool `long:"actually-create" description:"The default action is to dry run the action and skip the actual task create. It will log what it intends to do."`
 
+	Concurrency int `long:"concurrency" description:"The number of entries to create tasks for at the same time" default:"1"`
+	RateLimit   int `long:"rate-limit" description:"The max number of entries to create per second when actually creating, 0 for no limit" default:"5"`
+
+	Validate bool `long:"validate" description:"Check every entry of the config and print a report of what would be created without creating anything. Fails on any problem found."`
+
+	Resume string `long:"resume" description:"The run manifest of a previous run, only the entries that failed or never ran are retried."`
+
+	AllowDuplicates bool `long:"allow-duplicates" description:"Create tasks even when the owner already has a task with the same title and projects, by default they are skipped."`
+
 	output io.Writer
 	// The phab conduit client for the command to share the client session
 	client *gonduit.Conn
@@ -99,6 +132,18 @@ func (pc *phabBulkCreateCommand) Execute(_ []string) error {
 	if err := yaml.Unmarshal(yamlFile, &conf); err != nil {
 		log.Fatalf("failed to unmarshal config fle: %v", err)
 	}
+	if pc.Roster != "" {
+		conf.Roster = pc.Roster
+	} else if conf.Roster != "" {
+		conf.Roster = relativeTo(filepath.Dir(pc.EmailConfig), conf.Roster)
+	}
+	if conf.Roster != "" {
+		entries, err := loadRoster(conf.Roster, conf.DefaultVars)
+		if err != nil {
+			return err
+		}
+		conf.Emails = append(conf.Emails, entries...)
+	}
 
 	if len(pc.PhabAPIToken) == 0 {
 		return errNoAPIToken
@@ -116,155 +161,372 @@ func (pc *phabBulkCreateCommand) Execute(_ []string) error {
 	}
 	pc.client = client
 
-	projects := make(map[string]*entities.Project)
-	if projects, err = phabProjectLookup(pc.client, conf.CommonProjects); err != nil {
+	if conf.Emails, err = pc.expandOwnerGroups(conf.Emails); err != nil {
 		return err
 	}
 
-	// We dont want to fail but just log some errors on if we cant find what we were looking for
-	if err = compareProjects(conf.CommonProjects, projects); err != nil {
-		pc.logger.Error("errors looking up projects", zap.Error(err))
+	if pc.Validate {
+		return pc.validate(conf)
+	}
+
+	templates, err := loadTemplates(conf, filepath.Dir(pc.EmailConfig))
+	if err != nil {
 		return err
 	}
-	commonUsers := make(map[string]phab.User)
-	// default conduit will return all users
-	if commonUsers, err = getPhabUsers(pc.client, conf.CommonCCUsers); err != nil {
+
+	// nothing is created unless every user and project in the config exists
+	resolved, err := pc.resolveConfig(conf)
+	if err != nil {
+		pc.logger.Error("errors resolving config", zap.Error(err))
 		return err
 	}
+	pc.logger.Debug("resolved config", zap.Any("users", resolved.users), zap.Any("projects", resolved.projects))
 
-	pc.logger.Info("common users", zap.Any("users", commonUsers))
+	manifest := newBulkRunManifest(pc.EmailConfig, conf.Emails)
+	if pc.Resume != "" {
+		if manifest, err = loadBulkRunManifest(pc.Resume, conf.Emails); err != nil {
+			return err
+		}
+	}
+	if pc.ActuallyCreate {
+		pc.logger.Info("writing run manifest", zap.String("manifest", manifest.path))
+	}
 
-	var errs error
-	for _, emailConf := range conf.Emails {
-		descriptionTemplate, err := template.New("desc" + emailConf.Owner).Parse(conf.TaskTemplate)
-		if err != nil {
-			errs = multierr.Append(errs, err)
-			continue
+	for i, entry := range manifest.Entries {
+		if entry.done() {
+			pc.logger.Info("entry already done in a previous run, skipping",
+				zap.String("owner", conf.Emails[i].Owner),
+				zap.String("taskID", entry.TaskID),
+			)
 		}
+	}
 
-		titleTemplate, err := template.New("title" + emailConf.Owner).Parse(conf.TitleTemplate)
-		if err != nil {
-			errs = multierr.Append(errs, err)
-			continue
+	if conf.Umbrella != nil {
+		if err := pc.createUmbrella(conf, manifest, resolved); err != nil {
+			return err
 		}
+	}
+
+	return pc.createEntries(conf, templates, manifest, resolved)
+}
 
-		p := createTaskParams{
-			titleTemplate:       titleTemplate,
-			descriptionTemplate: descriptionTemplate,
-			emailConf:           emailConf,
-			commonProjects:      projects,
-			commonUsers:         commonUsers,
+// entryResult is the outcome of a single config entry handed back by a worker.
+type entryResult struct {
+	index int
+	entry bulkRunEntry
+	err   error
+}
+
+// createEntries creates the tasks of every entry not done yet using up to
+// Concurrency workers. Results are reported in the order of the config no
+// matter which worker finishes first.
+func (pc *phabBulkCreateCommand) createEntries(
+	conf yamlConfig,
+	templates *bulkTemplates,
+	manifest *bulkRunManifest,
+	resolved *resolvedConfig,
+) error {
+	concurrency := pc.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// only creating tasks is throttled, dry runs write nothing to phab
+	var limiter <-chan time.Time
+	if pc.RateLimit > 0 && pc.ActuallyCreate {
+		ticker := time.NewTicker(time.Second / time.Duration(pc.RateLimit))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	// entries done in a previous run count as finished from the start
+	finished := make([]bool, len(manifest.Entries))
+	for i, entry := range manifest.Entries {
+		finished[i] = entry.done()
+	}
+
+	jobs := make(chan int)
+	results := make(chan entryResult)
+	quit := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if limiter != nil {
+					<-limiter
+				}
+				// workers fill in a copy so only this goroutine ever touches the manifest
+				entry := *manifest.Entries[i]
+				err := pc.createEntryTask(conf, templates, conf.Emails[i], resolved, &entry)
+				results <- entryResult{index: i, entry: entry, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i, done := range finished {
+			if done {
+				continue
+			}
+			select {
+			case jobs <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs, saveErr error
+	ran := make([]bool, len(manifest.Entries))
+	entryErrs := make([]error, len(manifest.Entries))
+	next := 0
+	for res := range results {
+		entry := res.entry
+		if res.err != nil {
+			entry.Status = entryFailed
+			entry.Error = res.err.Error()
 		}
-		if err := pc.createTemplateTask(p); err != nil {
-			pc.logger.Error("failed to create task", zap.Error(err), zap.String("owner", p.emailConf.Owner))
-			errs = multierr.Append(errs, fmt.Errorf("failed for entry %q: %v", p.emailConf.Owner, err))
+		manifest.Entries[res.index] = &entry
+		entryErrs[res.index] = res.err
+		ran[res.index] = true
+
+		// keep the manifest up to date after every entry in case the run dies
+		if pc.ActuallyCreate && saveErr == nil {
+			if saveErr = manifest.save(); saveErr != nil {
+				close(quit)
+			}
+		}
+
+		for ; next < len(ran) && (ran[next] || finished[next]); next++ {
+			if ran[next] {
+				errs = multierr.Append(errs, pc.reportEntry(conf.Emails[next], manifest.Entries[next], entryErrs[next]))
+			}
 		}
 	}
 
-	return errs
+	return multierr.Append(errs, saveErr)
+}
+
+// reportEntry logs what happened to an entry, returning the error to add to the run.
+func (pc *phabBulkCreateCommand) reportEntry(emailConf emailConfig, entry *bulkRunEntry, err error) error {
+	switch {
+	case err != nil:
+		pc.logger.Error("failed to create task", zap.Error(err), zap.String("owner", emailConf.Owner))
+		return fmt.Errorf("failed for entry %q: %v", emailConf.Owner, err)
+	case entry.Status == entryExisting:
+		pc.logger.Info("task already exists, skipping",
+			zap.String("owner", emailConf.Owner),
+			zap.String("title", entry.Title),
+			zap.String("taskID", entry.TaskID),
+		)
+	case entry.Status == entryCreated:
+		if len(entry.CCPHIDs) > maxCCUsers {
+			pc.logger.Warn("more than 30 users ccd on the task", zap.String("id", entry.TaskID))
+		}
+		pc.logger.Info("created task for user",
+			zap.String("owner", emailConf.Owner),
+			zap.String("title", entry.Title),
+			zap.String("taskID", entry.TaskID),
+		)
+	default:
+		pc.logger.Info("DRY RUN",
+			zap.String("owner", emailConf.Owner),
+			zap.Strings("projects", entry.ProjectPHIDs),
+			zap.Strings("users", entry.CCPHIDs),
+			zap.String("title", entry.Title),
+			zap.String("parent", entry.ParentPHID),
+			zap.String("description", entry.description),
+		)
+	}
+	return nil
+}
+
+func (pc *phabBulkCreateCommand) createEntryTask(
+	conf yamlConfig,
+	templates *bulkTemplates,
+	emailConf emailConfig,
+	resolved *resolvedConfig,
+	entry *bulkRunEntry,
+) error {
+	return pc.createTemplateTask(createTaskParams{
+		templates: templates,
+		conf:      conf,
+		emailConf: emailConf,
+		resolved:  resolved,
+		entry:     entry,
+	})
 }
 
 type createTaskParams struct {
-	titleTemplate       *template.Template
-	descriptionTemplate *template.Template
-	emailConf           emailConfig
-	commonProjects      map[string]*entities.Project
-	commonUsers         map[string]phab.User
+	templates *bulkTemplates
+	conf      yamlConfig
+	emailConf emailConfig
+	resolved  *resolvedConfig
+	// entry is filled in with what happened to the task
+	entry *bulkRunEntry
 }
 
-func (pc *phabBulkCreateCommand) createTemplateTask(p createTaskParams) error {
-	emailProjects, err := phabProjectLookup(pc.client, p.emailConf.Projects)
-	if err != nil {
-		return err
-	}
-	// We dont want to fail but just log some errors on if we cant find what we were looking for
-	if err = compareProjects(p.emailConf.Projects, emailProjects); err != nil {
-		pc.logger.Error("errors looking up email projects", zap.Error(err))
-		return errors.Wrapf(err, "failed to find project for email config: %v", p.emailConf.Owner)
+// templateData is what the title and description templates are executed with.
+// The entry is embedded so {{ .Owner }} and {{ .InsertHere }} keep working.
+type templateData struct {
+	emailConfig
+	// Vars are the default vars of the config merged with the vars of the entry.
+	Vars map[string]interface{}
+	// User is the phab user of the owner, the zero value when unknown.
+	User phab.User
+	// ProjectNames are the names of all the projects the task is tagged with.
+	ProjectNames []string
+}
+
+// data returns the template data of the entry.
+func (p createTaskParams) data() templateData {
+	d := templateData{
+		emailConfig: p.emailConf,
+		Vars:        make(map[string]interface{}, len(p.conf.DefaultVars)+len(p.emailConf.Vars)),
+		User:        p.resolved.users[p.emailConf.Owner],
 	}
-	emailUsers := make(map[string]phab.User)
-	if emailUsers, err = getPhabUsers(pc.client, p.emailConf.CCUsers); err != nil {
-		return err
+	for k, v := range p.conf.DefaultVars {
+		d.Vars[k] = v
 	}
-
-	pc.logger.Debug("email users", zap.Any("users", emailUsers))
-
-	owner, err := getPhabUsers(pc.client, []string{p.emailConf.Owner})
-	if err != nil {
-		return errors.Wrapf(err, "failed to find phab user: %v", p.emailConf.Owner)
+	for k, v := range p.emailConf.Vars {
+		d.Vars[k] = v
 	}
-	pc.logger.Debug("owner user found", zap.Any("users", owner))
-
-	if err := compareUsers([]string{p.emailConf.Owner}, owner); err != nil {
-		pc.logger.Error("errors looking up owner user", zap.Error(err))
-		return errors.Wrapf(err, "failed to find user for email config: %v", p.emailConf.Owner)
+	for _, project := range p.resolved.entryProjects(p.conf, p.emailConf) {
+		d.ProjectNames = append(d.ProjectNames, project.Name)
 	}
+	return d
+}
 
+// render executes the title and description templates for the entry.
+func (p createTaskParams) render() (title, description string, err error) {
+	data := p.data()
 	titleBuf := &bytes.Buffer{}
-	if err := p.titleTemplate.Execute(titleBuf, p.emailConf); err != nil {
-		return errors.Wrapf(err, "failed to execute title template")
+	if err := p.templates.title.Execute(titleBuf, data); err != nil {
+		return "", "", errors.Wrapf(err, "failed to execute title template")
 	}
 
 	descBuf := &bytes.Buffer{}
-	if err := p.descriptionTemplate.Execute(descBuf, p.emailConf); err != nil {
-		return errors.Wrapf(err, "failed to execute description template")
+	if err := p.templates.description.Execute(descBuf, data); err != nil {
+		return "", "", errors.Wrapf(err, "failed to execute description template")
 	}
+	// template files and yaml block scalars end in a newline no title should have
+	return strings.TrimSpace(titleBuf.String()), descBuf.String(), nil
+}
 
-	// working with what i have now...
-	var allProjects []*entities.Project
-	for _, p := range p.commonProjects {
-		allProjects = append(allProjects, p)
+func (pc *phabBulkCreateCommand) createTemplateTask(p createTaskParams) error {
+	owner, ok := p.resolved.users[p.emailConf.Owner]
+	if !ok {
+		return fmt.Errorf("failed to find user for email config: %q", p.emailConf.Owner)
 	}
-	for _, p := range emailProjects {
-		allProjects = append(allProjects, p)
+
+	title, description, err := p.render()
+	if err != nil {
+		return err
 	}
 
+	allProjects := p.resolved.entryProjects(p.conf, p.emailConf)
 	var projectPHIDs []string
 	for _, p := range allProjects {
 		projectPHIDs = append(projectPHIDs, p.PHID)
 	}
-	// just making it work now...
-	var allUsers []phab.User
-	for _, u := range p.commonUsers {
-		allUsers = append(allUsers, u)
-	}
-	for _, u := range emailUsers {
-		allUsers = append(allUsers, u)
+	allUsers := p.resolved.entryCCUsers(p.conf, p.emailConf)
+
+	p.entry.Title = title
+	p.entry.OwnerPHID = owner.PHID
+	p.entry.ProjectPHIDs = projectPHIDs
+	p.entry.CCPHIDs = nil
+	for _, u := range allUsers {
+		p.entry.CCPHIDs = append(p.entry.CCPHIDs, u.PHID)
 	}
-	// finally create a task :D
-	if pc.ActuallyCreate {
-		newTask, err := pc.createNewPhabTask(titleBuf.String(), descBuf.String(), projectPHIDs, owner[p.emailConf.Owner], allUsers)
+
+	parentPHID := p.resolved.entryParent(p.conf, p.emailConf)
+	p.entry.ParentPHID = parentPHID
+
+	existing, err := pc.findExistingTask(title, owner.PHID, projectPHIDs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to look up existing tasks for owner: %v", p.emailConf.Owner)
+	}
+	switch {
+	case existing != nil && !pc.AllowDuplicates:
+		p.entry.Status = entryExisting
+		p.entry.TaskID = existing.ObjectName
+		p.entry.TaskPHID = existing.PHID
+	case pc.ActuallyCreate:
+		// finally create a task :D
+		newTask, err := pc.createNewPhabTask(title, description, projectPHIDs, owner, allUsers, p.conf.entryFields(p.emailConf))
 		if err != nil {
 			return errors.Wrapf(err, "failed to create new task for owner: %v", p.emailConf.Owner)
 		}
-		if len(allUsers) > 30 {
-			pc.logger.Warn("more than 30 users ccd on the task", zap.String("id", newTask.ID))
-		}
-		pc.logger.Info("created task for user",
-			zap.String("owner", p.emailConf.Owner),
-			// zap.Strings("projectPHIDs", projectPHIDs),
-			// zap.Any("users", allUsers),
-			zap.Stringer("title", titleBuf),
-			zap.String("taskID", newTask.ObjectName),
-		)
+		p.entry.Status = entryCreated
+		p.entry.TaskID = newTask.ObjectName
+		p.entry.TaskPHID = newTask.PHID
+		p.entry.Error = ""
+	default:
+		p.entry.description = description
 		return nil
 	}
-	pc.logger.Info("DRY RUN",
-		zap.String("owner", p.emailConf.Owner),
-		zap.Any("project", allProjects),
-		zap.Any("users", allUsers),
-		zap.Stringer("title", titleBuf),
-		zap.Stringer("description", descBuf),
-	)
 
+	// existing tasks are linked and get their subtype as well so resuming a run fixes a failed edit
+	if !pc.ActuallyCreate {
+		return nil
+	}
+	if parentPHID != "" {
+		if err := pc.linkParent(p.entry.TaskPHID, parentPHID); err != nil {
+			return errors.Wrapf(err, "failed to link %s to its parent task", p.entry.TaskID)
+		}
+	}
+	if subtype := p.conf.entryFields(p.emailConf).Subtype; subtype != "" {
+		return errors.Wrapf(pc.setSubtype(p.entry.TaskPHID, subtype), "failed to set the subtype of %s", p.entry.TaskID)
+	}
 	return nil
 }
 
+// existingTaskPageSize is the number of tasks findExistingTask asks for at once.
+const existingTaskPageSize = 100
+
+// findExistingTask returns a task of the owner with the exact same title that
+// is tagged with all the projects, so re-running a config skips the entries
+// that already succeeded. nil is returned when there is no such task.
+// Titles rendered from something that changes between runs, like {{ now }},
+// never match a task of an earlier run so those entries are created again.
+func (pc *phabBulkCreateCommand) findExistingTask(title, ownerPHID string, projectPHIDs []string) (*entities.ManiphestTask, error) {
+	req := requests.ManiphestQueryRequest{
+		OwnerPHIDs:   []string{ownerPHID},
+		ProjectPHIDs: projectPHIDs,
+		Status:       "status-any",
+		// newest first so a task from a recent run is found within the first pages
+		Order: "order-created",
+		Limit: existingTaskPageSize,
+	}
+	for {
+		res, err := pc.client.ManiphestQuery(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range *res {
+			if task.Title == title {
+				return task, nil
+			}
+		}
+		if len(*res) < existingTaskPageSize {
+			return nil, nil
+		}
+		req.Offset += existingTaskPageSize
+	}
+}
+
 func (pc *phabBulkCreateCommand) createNewPhabTask(
 	title, description string,
 	projects []string,
 	owner phab.User,
 	ccUsers []phab.User,
+	fields taskFields,
 ) (*entities.ManiphestTask, error) {
 	if len(ccUsers) == 0 {
 		return nil, errNoUsersSpecified
@@ -272,6 +534,14 @@ func (pc *phabBulkCreateCommand) createNewPhabTask(
 	if len(projects) == 0 {
 		return nil, errNoProjectSpecified
 	}
+	priority, err := fields.priority()
+	if err != nil {
+		return nil, err
+	}
+	aux, err := fields.auxiliary()
+	if err != nil {
+		return nil, err
+	}
 
 	var ccUserIDs []string
 	for _, user := range ccUsers {
@@ -285,6 +555,7 @@ func (pc *phabBulkCreateCommand) createNewPhabTask(
 		zap.String("description", description),
 		zap.Any("owner", owner),
 		zap.Any("ccUsers", ccUserIDs),
+		zap.Any("fields", fields),
 	)
 
 	if !pc.ActuallyCreate {
@@ -297,9 +568,11 @@ func (pc *phabBulkCreateCommand) createNewPhabTask(
 		OwnerPHID:    owner.PHID,
 		ProjectPHIDs: projects,
 		CCPHIDs:      ccUserIDs,
-		Auxiliary:    Aux{Type: "task"},
+		Priority:     priority,
+		ViewPolicy:   fields.ViewPolicy,
+		EditPolicy:   fields.EditPolicy,
+		Auxiliary:    aux,
 	}
-	// TODO: figure out how to specify the task type since we have custom types
 	if err := pc.client.Call("maniphest.createtask", &req, &mt); err != nil {
 		return nil, err
 	}
@@ -344,3 +617,18 @@ func getPhabUsers(client *gonduit.Conn, contactUsers []string) (map[string]phab.
 	}
 	return userMap, multierr.Combine(errs...)
 }
+
+// getPhabUsersByPHID returns the users of the PHIDs keyed by PHID.
+func getPhabUsersByPHID(client *gonduit.Conn, phids []string) (map[string]phab.User, error) {
+	users := make(map[string]phab.User, len(phids))
+	for _, chunk := range chunkStrings(phids, defaultBatchSize) {
+		var res phab.UserQueryResponse
+		if err := client.Call("user.query", &phab.UserQueryRequest{PHIDs: chunk}, &res); err != nil {
+			return nil, err
+		}
+		for _, user := range res {
+			users[user.PHID] = user
+		}
+	}
+	return users, nil
+}
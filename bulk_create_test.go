@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/responses"
+	"github.com/etcinit/gonduit/test/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/yaml.v2"
+)
+
+const testBulkConfig = `
+taskTemplate: Please do the thing {{ .InsertHere }}
+titleTemplate: Hello {{ .Owner }}
+commonProjects:
+- team
+commonCCUsers:
+- bean
+emails:
+- owner: alice
+  insertHere: now
+`
+
+// newTestBulkServer returns a fake phab knowing the users alice, bean, bob and carol
+// and the project team of alice, bob and carol.
+func newTestBulkServer() *server.Server {
+	s := server.New()
+	s.RegisterCapabilities()
+	s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{
+		"result": phab.UserQueryResponse{
+			{UserName: "alice", PHID: "PHID-USER-alice"},
+			{UserName: "bean", PHID: "PHID-USER-bean"},
+			{UserName: "bob", PHID: "PHID-USER-bob"},
+			{UserName: "carol", PHID: "PHID-USER-carol"},
+		},
+	})
+	s.RegisterMethod("project.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ProjectQueryResponse{
+			Data: map[string]entities.Project{
+				"PHID-PROJ-team": {
+					Name:    "team",
+					PHID:    "PHID-PROJ-team",
+					Members: []string{"PHID-USER-carol", "PHID-USER-bob", "PHID-USER-alice"},
+				},
+			},
+		},
+	})
+	return s
+}
+
+func newTestBulkCreateCommand(t *testing.T, s *server.Server, config string) *phabBulkCreateCommand {
+	dir, err := ioutil.TempDir("", "bulk-create")
+	require.NoError(t, err)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(config), 0644))
+
+	cmd, ok := newPhabBulkCreateCommand(&options{}, zap.NewNop()).(*phabBulkCreateCommand)
+	require.True(t, ok, "conversion to phabBulkCreateCommand failed")
+	cmd.output = ioutil.Discard
+	cmd.PhabURI = s.GetURL()
+	cmd.PhabAPIToken = "some-token"
+	cmd.EmailConfig = configFile
+	return cmd
+}
+
+func TestBulkCreateSkipsExisting(t *testing.T) {
+	tests := []struct {
+		msg             string
+		existing        responses.ManiphestQueryResponse
+		allowDuplicates bool
+		wantErr         string
+	}{
+		{
+			msg: "existing task is skipped",
+			existing: responses.ManiphestQueryResponse{
+				"PHID-TASK-1": &entities.ManiphestTask{ObjectName: "T1", Title: "Hello alice"},
+			},
+		},
+		{
+			msg: "other titles are created",
+			existing: responses.ManiphestQueryResponse{
+				"PHID-TASK-1": &entities.ManiphestTask{ObjectName: "T1", Title: "Hello bob"},
+			},
+			wantErr: "failed to create new task for owner: alice",
+		},
+		{
+			msg: "duplicates are created when allowed",
+			existing: responses.ManiphestQueryResponse{
+				"PHID-TASK-1": &entities.ManiphestTask{ObjectName: "T1", Title: "Hello alice"},
+			},
+			allowDuplicates: true,
+			wantErr:         "failed to create new task for owner: alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := newTestBulkServer()
+			defer s.Close()
+			// maniphest.createtask is not registered so any create attempt fails
+			s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": tt.existing})
+
+			cmd := newTestBulkCreateCommand(t, s, testBulkConfig)
+			defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+			cmd.ActuallyCreate = true
+			cmd.AllowDuplicates = tt.allowDuplicates
+
+			err := cmd.Execute(nil /* args */)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBulkCreateExistingPages(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+	rs := newRecordingServer(t, s)
+	defer rs.Close()
+	// the first page is full of other tasks, the task of the earlier run is on the second
+	rs.answer = func(method string, params json.RawMessage) (map[string]interface{}, bool) {
+		if method != "maniphest.query" {
+			return nil, false
+		}
+		var req struct {
+			Offset int `json:"offset"`
+		}
+		require.NoError(t, json.Unmarshal(params, &req))
+		page := make(responses.ManiphestQueryResponse)
+		if req.Offset == 0 {
+			for i := 0; i < existingTaskPageSize; i++ {
+				phid := fmt.Sprintf("PHID-TASK-%d", i)
+				page[phid] = &entities.ManiphestTask{PHID: phid, Title: "Something else"}
+			}
+		} else {
+			page["PHID-TASK-old"] = &entities.ManiphestTask{PHID: "PHID-TASK-old", ObjectName: "T1", Title: "Hello alice"}
+		}
+		return map[string]interface{}{"result": page}, true
+	}
+
+	// maniphest.createtask is not registered so any create attempt fails
+	cmd := newTestBulkCreateCommand(t, s, testBulkConfig)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	cmd.PhabURI = rs.URL
+	cmd.ActuallyCreate = true
+	require.NoError(t, cmd.Execute(nil /* args */))
+	assert.Len(t, rs.params("maniphest.query"), 2)
+}
+
+func TestBulkCreateManifestResume(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+	// maniphest.createtask is not registered so the create fails
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": responses.ManiphestQueryResponse{}})
+
+	cmd := newTestBulkCreateCommand(t, s, testBulkConfig)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	cmd.ActuallyCreate = true
+	require.Error(t, cmd.Execute(nil /* args */))
+
+	manifests, err := filepath.Glob(filepath.Join(filepath.Dir(cmd.EmailConfig), "config.*.run.json"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+
+	manifest, err := loadBulkRunManifest(manifests[0], []emailConfig{{Owner: "alice"}})
+	require.NoError(t, err)
+	entry := manifest.Entries[0]
+	assert.Equal(t, entryFailed, entry.Status)
+	assert.Equal(t, "Hello alice", entry.Title)
+	assert.Equal(t, "PHID-USER-alice", entry.OwnerPHID)
+	assert.Equal(t, []string{"PHID-PROJ-team"}, entry.ProjectPHIDs)
+	assert.Contains(t, entry.Error, "failed to create new task for owner: alice")
+
+	_, err = loadBulkRunManifest(manifests[0], []emailConfig{{Owner: "bob"}})
+	assert.EqualError(t, err, `manifest entry 0 is for "alice" but the config is for "bob"`)
+
+	// pretend the retry worked out of band, resuming has nothing left to do
+	entry.Status = entryCreated
+	require.NoError(t, manifest.save())
+	cmd.Resume = manifests[0]
+	assert.NoError(t, cmd.Execute(nil /* args */))
+}
+
+func TestBulkCreateConcurrentOrder(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": responses.ManiphestQueryResponse{}})
+
+	config := `
+taskTemplate: Please do the thing
+titleTemplate: Hello {{ .Owner }}
+commonProjects:
+- team
+commonCCUsers:
+- bean
+emails:
+- owner: alice
+- owner: bob
+- owner: carol
+- owner: bob
+`
+	cmd := newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+
+	logcore, obsLogs := observer.New(zap.InfoLevel)
+	cmd.logger = zap.New(logcore)
+	cmd.Concurrency = 3
+	// a dry run is not throttled, four entries at one a second would take four seconds
+	cmd.RateLimit = 1
+
+	start := time.Now()
+	require.NoError(t, cmd.Execute(nil /* args */))
+	assert.True(t, time.Since(start) < 2*time.Second, "the dry run was rate limited")
+
+	var got []string
+	for _, entry := range obsLogs.FilterMessage("DRY RUN").AllUntimed() {
+		got = append(got, entry.ContextMap()["title"].(string))
+	}
+	assert.Equal(t, []string{"Hello alice", "Hello bob", "Hello carol", "Hello bob"}, got)
+}
+
+func TestBulkCreateUnknownNames(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+
+	config := `
+taskTemplate: Please do the thing
+titleTemplate: Hello {{ .Owner }}
+commonProjects:
+- team
+emails:
+- owner: alice
+  ccusers:
+  - erin
+- owner: dan
+  projects:
+  - typo
+`
+	cmd := newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	cmd.ActuallyCreate = true
+
+	// no maniphest method is registered, failing validation must stop before any task lookup
+	err := cmd.Execute(nil /* args */)
+	require.Error(t, err)
+	assert.Equal(t, "config references unknown users or projects: "+
+		"user not found in phab: erin; user not found in phab: dan; project not found: typo", err.Error())
+}
+
+func TestBulkCreateValidate(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+
+	config := `
+taskTemplate: Please do the thing {{ .InsertHere }}
+titleTemplate: Hello {{ .Owner }}
+commonProjects:
+- team
+commonCCUsers:
+- bean
+emails:
+- owner: alice
+  insertHere: now
+- owner: dan
+  ccusers:
+  - erin
+`
+	cmd := newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	var out bytes.Buffer
+	cmd.output = &out
+	cmd.Validate = true
+
+	// nothing is created or looked up in maniphest while validating
+	err := cmd.Execute(nil /* args */)
+	require.Error(t, err)
+	assert.Equal(t, "validation failed with 2 problems", err.Error())
+	assert.Contains(t, out.String(), "Hello alice")
+	assert.Contains(t, out.String(), `entry 1 (dan): unknown owner "dan"`)
+	assert.Contains(t, out.String(), `entry 1 (dan): unknown cc user "erin"`)
+
+	// an entry without projects and cc users gets both problems at once
+	cmd = newTestBulkCreateCommand(t, s, `
+taskTemplate: Please do the thing
+titleTemplate: Hello {{ .Owner }}
+emails:
+- owner: alice
+`)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	out.Reset()
+	cmd.output = &out
+	cmd.Validate = true
+
+	err = cmd.Execute(nil /* args */)
+	require.Error(t, err)
+	assert.Equal(t, "validation failed with 2 problems", err.Error())
+	assert.Contains(t, out.String(), "entry 0 (alice): "+errNoProjectSpecified.Error())
+	assert.Contains(t, out.String(), "entry 0 (alice): "+errNoUsersSpecified.Error())
+}
+
+func TestBulkCreateEntryFields(t *testing.T) {
+	config := `
+priority: low
+viewPolicy: users
+auxiliary:
+  custom:quarter: Q2
+emails:
+- owner: alice
+- owner: bob
+  priority: high
+  subtype: bug
+  dueDate: "2018-06-01"
+  dueDateField: custom:deadline
+  auxiliary:
+    custom:quarter: Q3
+- owner: carol
+  priority: whenever
+- owner: dan
+  auxiliary:
+    quarter: Q3
+`
+	var conf yamlConfig
+	require.NoError(t, yaml.Unmarshal([]byte(config), &conf))
+	require.Len(t, conf.Emails, 4)
+
+	alice := conf.entryFields(conf.Emails[0])
+	priority, err := alice.priority()
+	require.NoError(t, err)
+	assert.Equal(t, 25, *priority)
+	assert.Equal(t, "users", alice.ViewPolicy)
+	aux, err := alice.auxiliary()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{auxTaskType: "task", "custom:quarter": "Q2"}, aux)
+
+	bob := conf.entryFields(conf.Emails[1])
+	priority, err = bob.priority()
+	require.NoError(t, err)
+	assert.Equal(t, 80, *priority)
+	assert.Equal(t, "users", bob.ViewPolicy)
+	aux, err = bob.auxiliary()
+	require.NoError(t, err)
+	assert.Equal(t, "bug", bob.Subtype)
+	assert.Equal(t, "task", aux[auxTaskType])
+	assert.Equal(t, "Q3", aux["custom:quarter"])
+	assert.Contains(t, aux, "custom:deadline")
+	assert.NotContains(t, aux, defaultDueDateField)
+
+	_, err = conf.entryFields(conf.Emails[2]).priority()
+	assert.EqualError(t, err, `unknown priority: "whenever"`)
+	_, err = conf.entryFields(conf.Emails[3]).auxiliary()
+	assert.EqualError(t, err, `auxiliary field "quarter" must start with std:maniphest: or custom:`)
+
+	assert.EqualError(t, conf.validateFields(), `entry 2 (carol): unknown priority: "whenever"; `+
+		`entry 3 (dan): auxiliary field "quarter" must start with std:maniphest: or custom:`)
+}
+
+func TestBulkCreateSubtype(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": responses.ManiphestQueryResponse{}})
+	s.RegisterMethod("maniphest.createtask", http.StatusOK, map[string]interface{}{
+		"result": entities.ManiphestTask{ObjectName: "T2", PHID: "PHID-TASK-2"},
+	})
+	s.RegisterMethod("maniphest.edit", http.StatusOK, map[string]interface{}{"result": phab.ManiphestEditResponse{}})
+	rs := newRecordingServer(t, s)
+	defer rs.Close()
+
+	// an invalid field fails a dry run before any entry is previewed
+	cmd := newTestBulkCreateCommand(t, s, testBulkConfig+"priority: whenever\n")
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	err := cmd.Execute(nil /* args */)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `config has invalid task fields: entry 0 (alice): unknown priority: "whenever"`)
+
+	cmd = newTestBulkCreateCommand(t, s, testBulkConfig+"subtype: bug\n")
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	cmd.PhabURI = rs.URL
+	cmd.ActuallyCreate = true
+	require.NoError(t, cmd.Execute(nil /* args */))
+
+	edits := rs.params("maniphest.edit")
+	require.Len(t, edits, 1)
+	var req phab.ManiphestEditRequest
+	require.NoError(t, json.Unmarshal(edits[0], &req))
+	assert.Equal(t, "PHID-TASK-2", req.ObjectIdentifier)
+	assert.Equal(t, []phab.EditTransaction{{Type: phab.TransactionSubtype, Value: "bug"}}, req.Transactions)
+}
+
+func TestBulkCreateTemplateVars(t *testing.T) {
+	config := `
+taskTemplate: "{{ .InsertHere }} {{ .Vars.what }} by {{ .Vars.when }} in {{ .ProjectNames }}"
+titleTemplate: "Hello {{ .User.RealName }}"
+commonProjects:
+- team
+defaultVars:
+  what: migrate
+  when: friday
+emails:
+- owner: alice
+  insertHere: please
+  vars:
+    when: monday
+- owner: bob
+  vars:
+    what: upgrade
+`
+	var conf yamlConfig
+	require.NoError(t, yaml.Unmarshal([]byte(config), &conf))
+	resolved := &resolvedConfig{
+		users: map[string]phab.User{
+			"alice": {UserName: "alice", RealName: "Alice Liddell"},
+			"bob":   {UserName: "bob", RealName: "Bob Dobbs"},
+		},
+		projects: map[string]*entities.Project{"team": {Name: "team"}},
+	}
+	templates, err := loadTemplates(conf, "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		entry     emailConfig
+		wantTitle string
+		wantDesc  string
+	}{
+		{entry: conf.Emails[0], wantTitle: "Hello Alice Liddell", wantDesc: "please migrate by monday in [team]"},
+		{entry: conf.Emails[1], wantTitle: "Hello Bob Dobbs", wantDesc: " upgrade by friday in [team]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry.Owner, func(t *testing.T) {
+			title, description, err := createTaskParams{
+				templates: templates,
+				conf:      conf,
+				emailConf: tt.entry,
+				resolved:  resolved,
+			}.render()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTitle, title)
+			assert.Equal(t, tt.wantDesc, description)
+		})
+	}
+
+	conf.TaskTemplate = "{{ .Vars.missing }}"
+	templates, err = loadTemplates(conf, "")
+	require.NoError(t, err)
+	_, _, err = createTaskParams{
+		templates: templates,
+		conf:      conf,
+		emailConf: conf.Emails[0],
+		resolved:  resolved,
+	}.render()
+	assert.Error(t, err, "missing vars must fail the template")
+}
+
+func TestBulkCreateOwnerGroups(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+
+	config := `
+taskTemplate: Please do the thing
+titleTemplate: Hello {{ .Owner }}
+commonProjects:
+- team
+commonCCUsers:
+- bean
+emails:
+- owner: bean
+- ownersOf:
+  - team
+  owners:
+  - bean
+  exclude:
+  - bob
+`
+	cmd := newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	var out bytes.Buffer
+	cmd.output = &out
+	cmd.Validate = true
+
+	require.NoError(t, cmd.Execute(nil /* args */))
+	assert.Contains(t, out.String(), "all 4 entries are valid")
+	for _, title := range []string{"Hello alice", "Hello bean", "Hello carol"} {
+		assert.Contains(t, out.String(), title)
+	}
+	assert.NotContains(t, out.String(), "Hello bob")
+}
+
+func TestExpandEntries(t *testing.T) {
+	members := map[string][]string{"team": {"alice", "bob"}}
+	got, err := expandEntries([]emailConfig{
+		{Owner: "bean"},
+		{OwnersOf: []string{"team"}, Owners: []string{"alice", "carol"}, Exclude: []string{"carol"}, Projects: []string{"ops"}},
+	}, members)
+	require.NoError(t, err)
+	assert.Equal(t, []emailConfig{
+		{Owner: "bean"},
+		{Owner: "alice", Projects: []string{"ops"}},
+		{Owner: "bob", Projects: []string{"ops"}},
+	}, got)
+
+	_, err = expandEntries([]emailConfig{{Owners: []string{"bob"}, Exclude: []string{"bob"}}}, members)
+	assert.EqualError(t, err, "the owner group of entry 0 has no owners")
+}
+
+func TestBulkCreateUmbrella(t *testing.T) {
+	s := newTestBulkServer()
+	defer s.Close()
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": responses.ManiphestQueryResponse{}})
+	s.RegisterMethod("phid.lookup", http.StatusOK, map[string]interface{}{
+		"result": responses.PHIDLookupResponse{"T1": {Name: "T1", PHID: "PHID-TASK-1"}},
+	})
+	// every created task gets the same answer from the fake phab
+	s.RegisterMethod("maniphest.createtask", http.StatusOK, map[string]interface{}{
+		"result": entities.ManiphestTask{ObjectName: "T2", PHID: "PHID-TASK-2"},
+	})
+	s.RegisterMethod("maniphest.edit", http.StatusOK, map[string]interface{}{"result": phab.ManiphestEditResponse{}})
+
+	config := testBulkConfig + `
+- owner: bob
+  parentTask: T1
+parentTask: T1
+umbrella:
+  owner: bean
+  titleTemplate: Campaign
+  taskTemplate: Tracking all the tasks
+`
+	// a dry run previews the entries under the umbrella it would create
+	cmd := newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	logcore, obsLogs := observer.New(zap.InfoLevel)
+	cmd.logger = zap.New(logcore)
+	require.NoError(t, cmd.Execute(nil /* args */))
+	var parents []string
+	for _, entry := range obsLogs.FilterMessage("DRY RUN").AllUntimed() {
+		parents = append(parents, entry.ContextMap()["parent"].(string))
+	}
+	assert.Equal(t, []string{"PHID-TASK-1", `(umbrella "Campaign")`, "PHID-TASK-1"}, parents)
+
+	cmd = newTestBulkCreateCommand(t, s, config)
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	cmd.ActuallyCreate = true
+	require.NoError(t, cmd.Execute(nil /* args */))
+
+	manifests, err := filepath.Glob(filepath.Join(filepath.Dir(cmd.EmailConfig), "config.*.run.json"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+	manifest, err := readBulkRunManifest(manifests[0])
+	require.NoError(t, err)
+
+	require.NotNil(t, manifest.Umbrella)
+	assert.Equal(t, entryCreated, manifest.Umbrella.Status)
+	assert.Equal(t, "Campaign", manifest.Umbrella.Title)
+	assert.Equal(t, "PHID-TASK-1", manifest.Umbrella.ParentPHID, "the umbrella is a subtask of the config parent")
+	require.Len(t, manifest.Entries, 2)
+	assert.Equal(t, "PHID-TASK-2", manifest.Entries[0].ParentPHID, "entries are subtasks of the umbrella")
+	assert.Equal(t, "PHID-TASK-1", manifest.Entries[1].ParentPHID, "the parent of an entry wins over the umbrella")
+
+	// the unknown parent is caught before anything is created
+	cmd = newTestBulkCreateCommand(t, s, testBulkConfig+"parentTask: T404\n")
+	defer os.RemoveAll(filepath.Dir(cmd.EmailConfig))
+	err = cmd.Execute(nil /* args */)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parent task not found: T404")
+}
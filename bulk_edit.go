@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+var (
+	errNoEdits = errors.New("no edits given to apply to the tasks")
+	// the selected tasks are added together, an edit of a project and an owner
+	// would touch far more tasks than meant
+	errMixedSelection = errors.New("select the tasks to edit with only one of --tasks, --projects or --task_author")
+)
+
+// editPriorities maps the priority keywords of maniphest.edit to the priority
+// names maniphest.query returns.
+var editPriorities = map[string]string{
+	"unbreak": "Unbreak Now!",
+	"triage":  "Needs Triage",
+	"high":    "High",
+	"normal":  "Normal",
+	"low":     "Low",
+	"wish":    "Wishlist",
+}
+
+// editStatuses are the task statuses maniphest.edit knows about.
+var editStatuses = []string{"open", "resolved", "wontfix", "invalid", "duplicate", "spite"}
+
+type phabBulkEditCommand struct {
+	baseCommand
+
+	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
+	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
+
+	taskSelection
+
+	SetOwner       string `long:"set-owner" description:"The username to reassign the tasks to"`
+	SetPriority    string `long:"set-priority" description:"The priority keyword to set, like high or normal"`
+	SetStatus      string `long:"set-status" description:"The status to set, like resolved or wontfix"`
+	AddProjects    string `long:"add-projects" description:"Comma sep list of projects to tag the tasks with"`
+	RemoveProjects string `long:"remove-projects" description:"Comma sep list of projects to remove from the tasks"`
+	AddCCs         string `long:"add-ccs" description:"Comma sep list of usernames to cc on the tasks"`
+	Comment        string `long:"comment" description:"A comment to leave on every task"`
+
+	ActuallyEdit bool `long:"actually-edit" description:"The default action is to dry run and only show what would change on every task."`
+
+	output io.Writer
+	// The phab conduit client for the command to share the client session
+	client *gonduit.Conn
+}
+
+func newPhabBulkEditCommand(opts *options, logger *zap.Logger) command {
+	return &phabBulkEditCommand{
+		baseCommand: newBaseCommand(
+			"phab-bulk-edit",
+			"Edit a set of existing tasks.",
+			"Reassign, re-tag, re-prioritize or close every task matched by one of a list of tasks, projects or owners. Shows what would change on every task unless --actually-edit is given.",
+			opts, logger),
+		output: os.Stdout,
+	}
+}
+
+// bulkEdit holds the edit flags with every name resolved to a PHID.
+type bulkEdit struct {
+	owner          *phab.User
+	addProjects    map[string]*entities.Project
+	removeProjects map[string]*entities.Project
+	addCCs         map[string]phab.User
+}
+
+func (pc *phabBulkEditCommand) Execute(_ []string) error {
+	if pc.taskSelection.empty() {
+		return errNoTasksSelected
+	}
+	if pc.taskSelection.mixed() {
+		return errMixedSelection
+	}
+	if pc.SetOwner == "" && pc.SetPriority == "" && pc.SetStatus == "" && pc.AddProjects == "" &&
+		pc.RemoveProjects == "" && pc.AddCCs == "" && pc.Comment == "" {
+		return errNoEdits
+	}
+	if err := pc.validateEdit(); err != nil {
+		return err
+	}
+
+	client, err := dialPhab(pc.PhabURI, pc.PhabAPIToken)
+	if err != nil {
+		return err
+	}
+	pc.client = client
+
+	edit, err := pc.resolveEdit()
+	if err != nil {
+		return err
+	}
+	tasks, err := pc.selectTasks(pc.baseCommand, pc.client)
+	if err != nil {
+		return err
+	}
+	names, err := pc.currentNames(tasks)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, task := range tasks {
+		transactions, changes := pc.transactions(task, edit, names)
+		fmt.Fprintf(pc.output, "%s %s\n", task.ObjectName, task.Title)
+		if len(transactions) == 0 {
+			fmt.Fprintln(pc.output, "  nothing to change")
+			continue
+		}
+		for _, change := range changes {
+			fmt.Fprintf(pc.output, "  %s\n", change)
+		}
+		if !pc.ActuallyEdit {
+			continue
+		}
+
+		req := phab.ManiphestEditRequest{ObjectIdentifier: task.PHID, Transactions: transactions}
+		var res phab.ManiphestEditResponse
+		if err := pc.client.Call("maniphest.edit", &req, &res); err != nil {
+			pc.logger.Error("failed to edit task", zap.Error(err), zap.String("taskID", task.ObjectName))
+			errs = multierr.Append(errs, fmt.Errorf("failed to edit %s: %v", task.ObjectName, err))
+			continue
+		}
+		pc.logger.Info("edited task", zap.String("taskID", task.ObjectName), zap.Int("transactions", len(transactions)))
+	}
+	if !pc.ActuallyEdit {
+		pc.logger.Info("DRY RUN, no task was edited", zap.Int("tasks", len(tasks)))
+	}
+	return errs
+}
+
+// validateEdit rejects an unknown priority or status before any task is edited.
+func (pc *phabBulkEditCommand) validateEdit() error {
+	if _, ok := editPriorities[pc.SetPriority]; pc.SetPriority != "" && !ok {
+		keywords := make([]string, 0, len(editPriorities))
+		for keyword := range editPriorities {
+			keywords = append(keywords, keyword)
+		}
+		sort.Strings(keywords)
+		return fmt.Errorf("unknown priority %q, the priorities are %s", pc.SetPriority, strings.Join(keywords, ", "))
+	}
+	if pc.SetStatus != "" && !containsString(editStatuses, pc.SetStatus) {
+		return fmt.Errorf("unknown status %q, the statuses are %s", pc.SetStatus, strings.Join(editStatuses, ", "))
+	}
+	return nil
+}
+
+// resolveEdit looks up the users and projects named by the edit flags, every
+// unknown name fails the command before anything is edited.
+func (pc *phabBulkEditCommand) resolveEdit() (*bulkEdit, error) {
+	edit := &bulkEdit{}
+	usernames := splitList(pc.AddCCs)
+	if pc.SetOwner != "" {
+		usernames = append(usernames, pc.SetOwner)
+	}
+	users, err := getPhabUsers(pc.client, uniqueStrings(usernames))
+	if users == nil {
+		return nil, err
+	}
+	errs := err
+
+	projectNames := append(splitList(pc.AddProjects), splitList(pc.RemoveProjects)...)
+	projects, err := phabProjectLookup(pc.client, uniqueStrings(projectNames))
+	if err != nil {
+		return nil, err
+	}
+	errs = multierr.Append(errs, compareProjects(projectNames, projects))
+	if errs != nil {
+		return nil, errors.Wrap(errs, "edit references unknown users or projects")
+	}
+
+	if pc.SetOwner != "" {
+		owner := users[pc.SetOwner]
+		edit.owner = &owner
+	}
+	edit.addProjects = pickProjects(projects, splitList(pc.AddProjects))
+	edit.removeProjects = pickProjects(projects, splitList(pc.RemoveProjects))
+	edit.addCCs = make(map[string]phab.User)
+	for _, name := range splitList(pc.AddCCs) {
+		edit.addCCs[name] = users[name]
+	}
+	return edit, nil
+}
+
+// currentNames returns the names of the owners of the tasks keyed by PHID for the preview.
+func (pc *phabBulkEditCommand) currentNames(tasks []*entities.ManiphestTask) (map[string]string, error) {
+	var phids []string
+	for _, task := range tasks {
+		phids = append(phids, task.OwnerPHID)
+	}
+	users, err := getPhabUsersByPHID(pc.client, uniqueStrings(phids))
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(users))
+	for phid, user := range users {
+		names[phid] = user.UserName
+	}
+	return names, nil
+}
+
+// transactions returns the maniphest.edit transactions the edit makes to the
+// task along with a line per change. Edits the task already has are left out.
+func (pc *phabBulkEditCommand) transactions(
+	task *entities.ManiphestTask,
+	edit *bulkEdit,
+	names map[string]string,
+) ([]phab.EditTransaction, []string) {
+	var transactions []phab.EditTransaction
+	var changes []string
+
+	if edit.owner != nil && edit.owner.PHID != task.OwnerPHID {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionOwner, Value: edit.owner.PHID})
+		changes = append(changes, fmt.Sprintf("owner: %s -> %s", nameOr(names[task.OwnerPHID], "(none)"), edit.owner.UserName))
+	}
+	if pc.SetPriority != "" && !strings.EqualFold(editPriorities[pc.SetPriority], task.Priority) {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionPriority, Value: pc.SetPriority})
+		changes = append(changes, fmt.Sprintf("priority: %s -> %s", task.Priority, pc.SetPriority))
+	}
+	if pc.SetStatus != "" && pc.SetStatus != task.Status {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionStatus, Value: pc.SetStatus})
+		changes = append(changes, fmt.Sprintf("status: %s -> %s", task.Status, pc.SetStatus))
+	}
+
+	var add, remove, addNames, removeNames []string
+	for _, name := range splitList(pc.AddProjects) {
+		if project := edit.addProjects[name]; !containsString(task.ProjectPHIDs, project.PHID) {
+			add = append(add, project.PHID)
+			addNames = append(addNames, "+"+name)
+		}
+	}
+	for _, name := range splitList(pc.RemoveProjects) {
+		if project := edit.removeProjects[name]; containsString(task.ProjectPHIDs, project.PHID) {
+			remove = append(remove, project.PHID)
+			removeNames = append(removeNames, "-"+name)
+		}
+	}
+	if len(add) > 0 {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionProjectsAdd, Value: add})
+	}
+	if len(remove) > 0 {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionProjectsRemove, Value: remove})
+	}
+	if len(add)+len(remove) > 0 {
+		changes = append(changes, "projects: "+strings.Join(append(addNames, removeNames...), " "))
+	}
+
+	var ccs, ccNames []string
+	for _, name := range splitList(pc.AddCCs) {
+		if user := edit.addCCs[name]; !containsString(task.CCPHIDs, user.PHID) {
+			ccs = append(ccs, user.PHID)
+			ccNames = append(ccNames, "+"+name)
+		}
+	}
+	if len(ccs) > 0 {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionSubscribersAdd, Value: ccs})
+		changes = append(changes, "cc: "+strings.Join(ccNames, " "))
+	}
+
+	if pc.Comment != "" {
+		transactions = append(transactions, phab.EditTransaction{Type: phab.TransactionComment, Value: pc.Comment})
+		changes = append(changes, fmt.Sprintf("comment: %q", pc.Comment))
+	}
+	return transactions, changes
+}
+
+// pickProjects returns the projects of the names.
+func pickProjects(projects map[string]*entities.Project, names []string) map[string]*entities.Project {
+	picked := make(map[string]*entities.Project, len(names))
+	for _, name := range names {
+		picked[name] = projects[name]
+	}
+	return picked
+}
+
+func nameOr(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBulkEdit(t *testing.T) {
+	tests := []struct {
+		msg          string
+		actuallyEdit bool
+		noEditMethod bool
+		wantErr      string
+	}{
+		{msg: "dry run only previews", noEditMethod: true},
+		{msg: "tasks with changes are edited", actuallyEdit: true},
+		{msg: "failed edits are reported", actuallyEdit: true, noEditMethod: true, wantErr: "failed to edit T1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := newTaskServer(
+				phab.UserQueryResponse{
+					{UserName: "alice", PHID: "PHID-USER-alice"},
+					{UserName: "bean", PHID: "PHID-USER-bean"},
+					{UserName: "bob", PHID: "PHID-USER-bob"},
+				},
+				[]entities.Project{{Name: "ops", PHID: "PHID-PROJ-ops"}},
+				&entities.ManiphestTask{
+					ID: "2", PHID: "PHID-TASK-2", ObjectName: "T2", Title: "Second", Status: "open", Priority: "Needs Triage",
+					OwnerPHID: "PHID-USER-bob", ProjectPHIDs: []string{"PHID-PROJ-ops"}, CCPHIDs: []string{"PHID-USER-bean"},
+				},
+				&entities.ManiphestTask{
+					ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "First", Status: "open", Priority: "Normal",
+					OwnerPHID: "PHID-USER-alice",
+				},
+			)
+			defer s.Close()
+			if !tt.noEditMethod {
+				s.RegisterMethod("maniphest.edit", http.StatusOK, map[string]interface{}{"result": phab.ManiphestEditResponse{}})
+			}
+
+			rs := newRecordingServer(t, s)
+			defer rs.Close()
+
+			cmd, ok := newPhabBulkEditCommand(&options{}, zap.NewNop()).(*phabBulkEditCommand)
+			require.True(t, ok, "conversion to phabBulkEditCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.PhabURI = rs.URL
+			cmd.PhabAPIToken = "some-token"
+			cmd.Tasks = "T1,T2"
+			cmd.SetOwner = "bob"
+			cmd.AddProjects = "ops"
+			cmd.AddCCs = "bean"
+			cmd.SetPriority = "triage"
+			cmd.ActuallyEdit = tt.actuallyEdit
+
+			err := cmd.Execute(nil /* args */)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, "T1 First\n"+
+				"  owner: alice -> bob\n"+
+				"  priority: Normal -> triage\n"+
+				"  projects: +ops\n"+
+				"  cc: +bean\n"+
+				"T2 Second\n"+
+				"  nothing to change\n", out.String())
+
+			edits := rs.params("maniphest.edit")
+			if !tt.actuallyEdit {
+				assert.Empty(t, edits, "a dry run must not edit")
+				return
+			}
+			require.Len(t, edits, 1, "only T1 has changes")
+			var req phab.ManiphestEditRequest
+			require.NoError(t, json.Unmarshal(edits[0], &req))
+			assert.Equal(t, "PHID-TASK-1", req.ObjectIdentifier)
+			assert.Equal(t, []phab.EditTransaction{
+				{Type: phab.TransactionOwner, Value: "PHID-USER-bob"},
+				{Type: phab.TransactionPriority, Value: "triage"},
+				{Type: phab.TransactionProjectsAdd, Value: []interface{}{"PHID-PROJ-ops"}},
+				{Type: phab.TransactionSubscribersAdd, Value: []interface{}{"PHID-USER-bean"}},
+			}, req.Transactions)
+		})
+	}
+}
+
+func TestBulkEditNothingToDo(t *testing.T) {
+	cmd, ok := newPhabBulkEditCommand(&options{}, zap.NewNop()).(*phabBulkEditCommand)
+	require.True(t, ok, "conversion to phabBulkEditCommand failed")
+	assert.Equal(t, errNoTasksSelected, cmd.Execute(nil /* args */))
+
+	cmd.Tasks = "T1"
+	cmd.Projects = "ops"
+	assert.Equal(t, errMixedSelection, cmd.Execute(nil /* args */))
+
+	cmd.Projects = ""
+	assert.Equal(t, errNoEdits, cmd.Execute(nil /* args */))
+
+	cmd.SetPriority = "urgent"
+	assert.EqualError(t, cmd.Execute(nil /* args */), `unknown priority "urgent", the priorities are high, low, normal, triage, unbreak, wish`)
+
+	cmd.SetPriority = "high"
+	cmd.SetStatus = "done"
+	assert.EqualError(t, cmd.Execute(nil /* args */), `unknown status "done", the statuses are open, resolved, wontfix, invalid, duplicate, spite`)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	// auxTaskType is the custom field holding the type of a task.
+	auxTaskType = "std:maniphest:task_type"
+	// defaultDueDateField is the custom field the due date goes to unless the
+	// config names another one, phab has no due date of its own.
+	defaultDueDateField = "std:maniphest:due-date"
+
+	defaultTaskType = "task"
+	dueDateLayout   = "2006-01-02"
+)
+
+// priorities maps the maniphest priority names to the values maniphest.createtask takes.
+var priorities = map[string]int{
+	"unbreak now!": 100,
+	"unbreak":      100,
+	"needs triage": 90,
+	"triage":       90,
+	"high":         80,
+	"normal":       50,
+	"low":          25,
+	"wishlist":     0,
+}
+
+// taskFields are the optional fields of a created task. They can be set for
+// every task at the top of the config and overridden by each entry.
+type taskFields struct {
+	// Priority is a priority name like "high" or its numeric value.
+	Priority string `yaml:"priority,omitempty"`
+	// Subtype is the key of a maniphest subtype like "bug". maniphest.createtask
+	// does not take one so it is set with maniphest.edit once the task exists.
+	Subtype    string `yaml:"subtype,omitempty"`
+	ViewPolicy string `yaml:"viewPolicy,omitempty"`
+	EditPolicy string `yaml:"editPolicy,omitempty"`
+	// DueDate is formatted as 2006-01-02.
+	DueDate string `yaml:"dueDate,omitempty"`
+	// DueDateField is the custom field like custom:deadline the due date is
+	// written to, std:maniphest:due-date by default.
+	DueDateField string `yaml:"dueDateField,omitempty"`
+	// Auxiliary sets any std:maniphest:* or custom:* field directly.
+	Auxiliary map[string]interface{} `yaml:"auxiliary,omitempty"`
+	// ParentTask is the task like T123 that created tasks become subtasks of.
+	ParentTask string `yaml:"parentTask,omitempty"`
+}
+
+// merge returns the fields with every field set in override replacing its own.
+// Auxiliary fields are merged key by key.
+func (f taskFields) merge(override taskFields) taskFields {
+	merged := f
+	if override.Priority != "" {
+		merged.Priority = override.Priority
+	}
+	if override.Subtype != "" {
+		merged.Subtype = override.Subtype
+	}
+	if override.ViewPolicy != "" {
+		merged.ViewPolicy = override.ViewPolicy
+	}
+	if override.EditPolicy != "" {
+		merged.EditPolicy = override.EditPolicy
+	}
+	if override.DueDate != "" {
+		merged.DueDate = override.DueDate
+	}
+	if override.DueDateField != "" {
+		merged.DueDateField = override.DueDateField
+	}
+	if override.ParentTask != "" {
+		merged.ParentTask = override.ParentTask
+	}
+	merged.Auxiliary = make(map[string]interface{}, len(f.Auxiliary)+len(override.Auxiliary))
+	for k, v := range f.Auxiliary {
+		merged.Auxiliary[k] = v
+	}
+	for k, v := range override.Auxiliary {
+		merged.Auxiliary[k] = v
+	}
+	return merged
+}
+
+// priority returns the numeric priority, nil when none is set so phab picks its default.
+func (f taskFields) priority() (*int, error) {
+	if f.Priority == "" {
+		return nil, nil
+	}
+	if p, ok := priorities[strings.ToLower(f.Priority)]; ok {
+		return &p, nil
+	}
+	p, err := strconv.Atoi(f.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("unknown priority: %q", f.Priority)
+	}
+	return &p, nil
+}
+
+// auxiliary returns the custom fields of the task including its due date.
+func (f taskFields) auxiliary() (map[string]interface{}, error) {
+	aux := map[string]interface{}{auxTaskType: defaultTaskType}
+	for k, v := range f.Auxiliary {
+		if !strings.HasPrefix(k, "std:maniphest:") && !strings.HasPrefix(k, "custom:") {
+			return nil, fmt.Errorf("auxiliary field %q must start with std:maniphest: or custom:", k)
+		}
+		switch v.(type) {
+		case map[interface{}]interface{}:
+			return nil, fmt.Errorf("auxiliary field %q must not be a map", k)
+		}
+		aux[k] = v
+	}
+	if f.DueDate != "" {
+		due, err := time.ParseInLocation(dueDateLayout, f.DueDate, time.Local)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid due date: %q", f.DueDate)
+		}
+		field := f.DueDateField
+		if field == "" {
+			field = defaultDueDateField
+		}
+		aux[field] = due.Unix()
+	}
+	return aux, nil
+}
+
+// validateFields checks the task fields of the umbrella and every entry so a
+// bad priority or auxiliary field is caught by a dry run as well.
+func (conf yamlConfig) validateFields() error {
+	var errs error
+	check := func(name string, e emailConfig) {
+		fields := conf.entryFields(e)
+		if _, err := fields.priority(); err != nil {
+			errs = multierr.Append(errs, errors.Wrap(err, name))
+		}
+		if _, err := fields.auxiliary(); err != nil {
+			errs = multierr.Append(errs, errors.Wrap(err, name))
+		}
+	}
+	if conf.Umbrella != nil {
+		check("umbrella", conf.Umbrella.emailConfig)
+	}
+	for i, e := range conf.Emails {
+		check(fmt.Sprintf("entry %d (%s)", i, e.Owner), e)
+	}
+	return errs
+}
+
+// setSubtype changes the subtype of the task, setting it again does nothing.
+func (pc *phabBulkCreateCommand) setSubtype(taskPHID, subtype string) error {
+	req := phab.ManiphestEditRequest{
+		ObjectIdentifier: taskPHID,
+		Transactions: []phab.EditTransaction{
+			{Type: phab.TransactionSubtype, Value: subtype},
+		},
+	}
+	var res phab.ManiphestEditResponse
+	return pc.client.Call("maniphest.edit", &req, &res)
+}
+
+// entryFields returns the task fields of the entry falling back to the common ones.
+func (conf yamlConfig) entryFields(e emailConfig) taskFields {
+	return conf.taskFields.merge(e.taskFields)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// roleDisabled is the role phab gives users that can no longer log in.
+const roleDisabled = "disabled"
+
+// isGroup is true when the entry names a group of owners instead of a single one.
+func (e emailConfig) isGroup() bool {
+	return len(e.OwnersOf) > 0 || len(e.Owners) > 0
+}
+
+// expandOwnerGroups replaces every entry naming a group of owners with one
+// entry per member of the group. Members are sorted by username so a config
+// expands the same way every run and a run manifest can be resumed.
+func (pc *phabBulkCreateCommand) expandOwnerGroups(emails []emailConfig) ([]emailConfig, error) {
+	var projectNames []string
+	for i, e := range emails {
+		if !e.isGroup() {
+			continue
+		}
+		if e.Owner != "" {
+			return nil, fmt.Errorf("entry %d sets an owner and a group of owners", i)
+		}
+		projectNames = append(projectNames, e.OwnersOf...)
+	}
+	projectNames = uniqueStrings(projectNames)
+	if len(projectNames) == 0 {
+		// only plain owners or plain lists of them
+		return expandEntries(emails, nil)
+	}
+
+	projects, err := phabProjectLookup(pc.client, projectNames)
+	if err != nil {
+		return nil, err
+	}
+	if err := compareProjects(projectNames, projects); err != nil {
+		return nil, errors.Wrap(err, "failed to look up owner groups")
+	}
+
+	var memberPHIDs []string
+	for _, project := range projects {
+		memberPHIDs = append(memberPHIDs, project.Members...)
+	}
+	users, err := getPhabUsersByPHID(pc.client, uniqueStrings(memberPHIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up owner group members")
+	}
+
+	members := make(map[string][]string, len(projects))
+	for name, project := range projects {
+		for _, phid := range project.Members {
+			user, ok := users[phid]
+			if !ok {
+				continue
+			}
+			if containsString(user.Roles, roleDisabled) {
+				pc.logger.Info("skipping disabled member of owner group",
+					zap.String("project", name), zap.String("user", user.UserName))
+				continue
+			}
+			members[name] = append(members[name], user.UserName)
+		}
+		sort.Strings(members[name])
+	}
+	return expandEntries(emails, members)
+}
+
+// expandEntries returns the entries with every group entry replaced by one
+// entry per owner, members holds the usernames of each group project.
+func expandEntries(emails []emailConfig, members map[string][]string) ([]emailConfig, error) {
+	var expanded []emailConfig
+	for i, e := range emails {
+		if !e.isGroup() {
+			expanded = append(expanded, e)
+			continue
+		}
+
+		owners := append([]string{}, e.Owners...)
+		for _, project := range e.OwnersOf {
+			owners = append(owners, members[project]...)
+		}
+		var count int
+		for _, owner := range uniqueStrings(owners) {
+			if containsString(e.Exclude, owner) {
+				continue
+			}
+			entry := e
+			entry.Owner = owner
+			entry.Owners, entry.OwnersOf, entry.Exclude = nil, nil, nil
+			expanded = append(expanded, entry)
+			count++
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("the owner group of entry %d has no owners", i)
+		}
+	}
+	return expanded, nil
+}
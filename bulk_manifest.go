@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The states of an entry in a bulk create run manifest.
+const (
+	entryPending  = "pending"
+	entryCreated  = "created"
+	entryExisting = "existing"
+	entryFailed   = "failed"
+	// entryRolledBack is a created task that was closed again by a rollback
+	entryRolledBack = "rolled-back"
+)
+
+// bulkRunManifest records what a bulk create run did with every entry of the
+// config, so a failed run can be resumed and a bad one rolled back.
+type bulkRunManifest struct {
+	Config  string          `json:"config"`
+	Started time.Time       `json:"started"`
+	Entries []*bulkRunEntry `json:"entries"`
+	// Umbrella is the task created for the entries to be subtasks of.
+	Umbrella *bulkRunEntry `json:"umbrella,omitempty"`
+
+	path string
+}
+
+type bulkRunEntry struct {
+	Owner        string   `json:"owner"`
+	Status       string   `json:"status"`
+	Title        string   `json:"title,omitempty"`
+	OwnerPHID    string   `json:"ownerPHID,omitempty"`
+	ProjectPHIDs []string `json:"projectPHIDs,omitempty"`
+	CCPHIDs      []string `json:"ccPHIDs,omitempty"`
+	TaskID       string   `json:"taskID,omitempty"`
+	TaskPHID     string   `json:"taskPHID,omitempty"`
+	ParentPHID   string   `json:"parentPHID,omitempty"`
+	Error        string   `json:"error,omitempty"`
+
+	// description is only kept around to report dry runs
+	description string
+}
+
+// done returns true for entries that do not need to be retried.
+func (e *bulkRunEntry) done() bool {
+	return e.Status == entryCreated || e.Status == entryExisting
+}
+
+// manifestPath returns a new manifest file name next to the config file.
+func manifestPath(config string, started time.Time) string {
+	base := strings.TrimSuffix(config, filepath.Ext(config))
+	return fmt.Sprintf("%s.%s.run.json", base, started.Format("20060102-150405"))
+}
+
+// newBulkRunManifest returns a manifest with every entry pending.
+func newBulkRunManifest(config string, emails []emailConfig) *bulkRunManifest {
+	started := time.Now()
+	m := &bulkRunManifest{
+		Config:  config,
+		Started: started,
+		path:    manifestPath(config, started),
+	}
+	for _, e := range emails {
+		m.Entries = append(m.Entries, &bulkRunEntry{Owner: e.Owner, Status: entryPending})
+	}
+	return m
+}
+
+// loadBulkRunManifest reads the manifest of a previous run, the entries have
+// to line up with the config for it to be resumed.
+func loadBulkRunManifest(path string, emails []emailConfig) (*bulkRunManifest, error) {
+	m, err := readBulkRunManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Entries) != len(emails) {
+		return nil, fmt.Errorf("manifest has %d entries but the config has %d", len(m.Entries), len(emails))
+	}
+	for i, e := range emails {
+		if m.Entries[i].Owner != e.Owner {
+			return nil, fmt.Errorf("manifest entry %d is for %q but the config is for %q", i, m.Entries[i].Owner, e.Owner)
+		}
+	}
+	return m, nil
+}
+
+func readBulkRunManifest(path string) (*bulkRunManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read run manifest")
+	}
+	m := &bulkRunManifest{path: path}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse run manifest %s", path)
+	}
+	return m, nil
+}
+
+// save writes the manifest to a temp file first so a crash never leaves a
+// half written manifest behind.
+func (m *bulkRunManifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to write run manifest")
+	}
+	return os.Rename(tmp, m.path)
+}
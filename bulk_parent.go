@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/requests"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// umbrellaConfig is a task created before any of the entries. Every entry
+// without a parent task of its own becomes a subtask of it, the umbrella
+// itself becomes a subtask of the parent task of the config.
+type umbrellaConfig struct {
+	TaskTemplate      string `yaml:"taskTemplate"`
+	TitleTemplate     string `yaml:"titleTemplate"`
+	TaskTemplateFile  string `yaml:"taskTemplateFile,omitempty"`
+	TitleTemplateFile string `yaml:"titleTemplateFile,omitempty"`
+
+	emailConfig `yaml:",inline"`
+}
+
+// createUmbrella creates the umbrella task of the config unless the manifest
+// already has it from a previous run.
+func (pc *phabBulkCreateCommand) createUmbrella(conf yamlConfig, manifest *bulkRunManifest, resolved *resolvedConfig) error {
+	umbrella := conf.Umbrella
+	if manifest.Umbrella == nil {
+		manifest.Umbrella = &bulkRunEntry{Owner: umbrella.Owner, Status: entryPending}
+	}
+	if !manifest.Umbrella.done() {
+		templates, err := loadUmbrellaTemplates(conf, filepath.Dir(pc.EmailConfig))
+		if err != nil {
+			return err
+		}
+
+		err = pc.createEntryTask(conf, templates, umbrella.emailConfig, resolved, manifest.Umbrella)
+		if err != nil {
+			manifest.Umbrella.Status = entryFailed
+			manifest.Umbrella.Error = err.Error()
+		}
+		if pc.ActuallyCreate {
+			err = multierr.Append(err, manifest.save())
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to create the umbrella task")
+		}
+		if err := pc.reportEntry(umbrella.emailConfig, manifest.Umbrella, nil); err != nil {
+			return err
+		}
+	}
+	resolved.umbrellaPHID = manifest.Umbrella.TaskPHID
+	if resolved.umbrellaPHID == "" {
+		// a dry run creates no umbrella, preview the entries under the one it would create
+		resolved.umbrellaPHID = fmt.Sprintf("(umbrella %q)", manifest.Umbrella.Title)
+	}
+	return nil
+}
+
+// loadUmbrellaTemplates parses the templates of the umbrella, it shares the
+// partials but none of the templates of the entries.
+func loadUmbrellaTemplates(conf yamlConfig, configDir string) (*bulkTemplates, error) {
+	conf.TaskTemplate = conf.Umbrella.TaskTemplate
+	conf.TitleTemplate = conf.Umbrella.TitleTemplate
+	conf.TaskTemplateFile = conf.Umbrella.TaskTemplateFile
+	conf.TitleTemplateFile = conf.Umbrella.TitleTemplateFile
+	templates, err := loadTemplates(conf, configDir)
+	return templates, errors.Wrap(err, "failed to load the umbrella templates")
+}
+
+// entryParent returns the PHID of the task the entry becomes a subtask of,
+// its own parent task, the umbrella or the parent task of the config.
+func (r *resolvedConfig) entryParent(conf yamlConfig, e emailConfig) string {
+	switch {
+	case e.ParentTask != "":
+		return r.tasks[e.ParentTask]
+	case r.umbrellaPHID != "":
+		return r.umbrellaPHID
+	default:
+		return r.tasks[conf.ParentTask]
+	}
+}
+
+// parentTaskNames returns the names of every parent task in the config.
+func parentTaskNames(conf yamlConfig) []string {
+	names := []string{conf.ParentTask}
+	for _, e := range conf.Emails {
+		names = append(names, e.ParentTask)
+	}
+	if conf.Umbrella != nil {
+		names = append(names, conf.Umbrella.ParentTask)
+	}
+	return uniqueStrings(names)
+}
+
+// lookupTasks returns the PHIDs of the tasks keyed by name, unknown tasks are returned as an error.
+func (pc *phabBulkCreateCommand) lookupTasks(names []string) (map[string]string, error) {
+	tasks := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return tasks, nil
+	}
+	res, err := pc.client.PHIDLookup(requests.PHIDLookupRequest{Names: names})
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, name := range names {
+		task, ok := res[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("parent task not found: %s", name))
+			continue
+		}
+		tasks[name] = task.PHID
+	}
+	return tasks, multierr.Combine(errs...)
+}
+
+// linkParent makes the task a subtask of the parent, linking it again does nothing.
+func (pc *phabBulkCreateCommand) linkParent(taskPHID, parentPHID string) error {
+	req := phab.ManiphestEditRequest{
+		ObjectIdentifier: taskPHID,
+		Transactions: []phab.EditTransaction{
+			{Type: phab.TransactionParentsAdd, Value: []string{parentPHID}},
+		},
+	}
+	var res phab.ManiphestEditResponse
+	return pc.client.Call("maniphest.edit", &req, &res)
+}
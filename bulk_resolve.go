@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// resolvedConfig holds every user and project named anywhere in a config so
+// entries never have to look anything up on their own.
+type resolvedConfig struct {
+	users    map[string]phab.User
+	projects map[string]*entities.Project
+	// tasks are the PHIDs of the parent tasks
+	tasks map[string]string
+	// umbrellaPHID is set once the umbrella task of the config exists, a dry
+	// run sets it to a placeholder naming the umbrella it would create
+	umbrellaPHID string
+}
+
+// resolveConfig looks up all the users, projects and parent tasks of the config in batches.
+// Every unknown name and invalid task field is reported at once so a typo is
+// caught before any task is created. Everything that was found is still returned along with the error
+// of the unknown names, nil is only returned when phab could not be queried.
+func (pc *phabBulkCreateCommand) resolveConfig(conf yamlConfig) (*resolvedConfig, error) {
+	var usernames, projectNames []string
+	usernames = append(usernames, conf.CommonCCUsers...)
+	projectNames = append(projectNames, conf.CommonProjects...)
+	emails := conf.Emails
+	if conf.Umbrella != nil {
+		emails = append([]emailConfig{conf.Umbrella.emailConfig}, emails...)
+	}
+	for _, e := range emails {
+		usernames = append(usernames, e.Owner)
+		usernames = append(usernames, e.CCUsers...)
+		projectNames = append(projectNames, e.Projects...)
+	}
+	usernames = uniqueStrings(usernames)
+	projectNames = uniqueStrings(projectNames)
+
+	resolved := &resolvedConfig{
+		users:    make(map[string]phab.User),
+		projects: make(map[string]*entities.Project),
+	}
+	var errs error
+	for _, chunk := range chunkStrings(usernames, defaultBatchSize) {
+		users, err := getPhabUsers(pc.client, chunk)
+		if users == nil {
+			return nil, err
+		}
+		// missing users are collected so they can all be reported together
+		errs = multierr.Append(errs, err)
+		for name, user := range users {
+			resolved.users[name] = user
+		}
+	}
+	for _, chunk := range chunkStrings(projectNames, defaultBatchSize) {
+		projects, err := phabProjectLookup(pc.client, chunk)
+		if err != nil {
+			return nil, err
+		}
+		errs = multierr.Append(errs, compareProjects(chunk, projects))
+		for name, project := range projects {
+			resolved.projects[name] = project
+		}
+	}
+	tasks, err := pc.lookupTasks(parentTaskNames(conf))
+	if tasks == nil {
+		return nil, err
+	}
+	errs = multierr.Append(errs, err)
+	resolved.tasks = tasks
+	if errs != nil {
+		errs = errors.Wrap(errs, "config references unknown users or projects")
+	}
+	if err := conf.validateFields(); err != nil {
+		errs = multierr.Append(errs, errors.Wrap(err, "config has invalid task fields"))
+	}
+	return resolved, errs
+}
+
+// entryProjects returns the common and entry projects without duplicates,
+// unknown projects are left out.
+func (r *resolvedConfig) entryProjects(conf yamlConfig, e emailConfig) []*entities.Project {
+	var projects []*entities.Project
+	for _, name := range uniqueStrings(append(append([]string{}, conf.CommonProjects...), e.Projects...)) {
+		if project, ok := r.projects[name]; ok {
+			projects = append(projects, project)
+		}
+	}
+	return projects
+}
+
+// entryCCUsers returns the common and entry cc users without duplicates,
+// unknown users are left out.
+func (r *resolvedConfig) entryCCUsers(conf yamlConfig, e emailConfig) []phab.User {
+	var users []phab.User
+	for _, name := range uniqueStrings(append(append([]string{}, conf.CommonCCUsers...), e.CCUsers...)) {
+		if user, ok := r.users[name]; ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// uniqueStrings returns the list without duplicates and empty strings, keeping the order.
+func uniqueStrings(list []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, s := range list {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+var errNoManifest = errors.New("a run manifest is required to roll back a bulk create")
+
+type phabBulkRollbackCommand struct {
+	baseCommand
+
+	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
+	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
+
+	Manifest string `long:"manifest" description:"The run manifest written by phab-bulk-create"`
+	Status   string `long:"status" description:"The status to close the tasks with" default:"invalid" choice:"invalid" choice:"wontfix" choice:"resolved"`
+	Comment  string `long:"comment" description:"A comment to leave on every task explaining why it was closed"`
+
+	ActuallyClose bool `long:"actually-close" description:"The default action is to dry run the action and skip closing the tasks. It will log what it intends to do."`
+
+	// The phab conduit client for the command to share the client session
+	client *gonduit.Conn
+}
+
+func newPhabBulkRollbackCommand(opts *options, logger *zap.Logger) command {
+	return &phabBulkRollbackCommand{
+		baseCommand: newBaseCommand(
+			"phab-bulk-rollback",
+			"Close the tasks created by a bulk create run.",
+			"Using the run manifest of phab-bulk-create every task the run created is closed, optionally leaving a comment on why.",
+			opts, logger),
+	}
+}
+
+func (pc *phabBulkRollbackCommand) Execute(_ []string) error {
+	if len(pc.Manifest) == 0 {
+		return errNoManifest
+	}
+	manifest, err := readBulkRunManifest(pc.Manifest)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialPhab(pc.PhabURI, pc.PhabAPIToken)
+	if err != nil {
+		return err
+	}
+	pc.client = client
+
+	entries := manifest.Entries
+	if manifest.Umbrella != nil {
+		// the umbrella goes last so it is never closed before its subtasks
+		entries = append(entries[:len(entries):len(entries)], manifest.Umbrella)
+	}
+
+	var errs error
+	for _, entry := range entries {
+		// only tasks this run created are closed, existing tasks were there before
+		if entry.Status != entryCreated {
+			continue
+		}
+		if !pc.ActuallyClose {
+			pc.logger.Info("DRY RUN",
+				zap.String("owner", entry.Owner),
+				zap.String("taskID", entry.TaskID),
+				zap.String("title", entry.Title),
+				zap.String("status", pc.Status),
+			)
+			continue
+		}
+
+		if err := pc.closeTask(entry); err != nil {
+			pc.logger.Error("failed to close task", zap.Error(err), zap.String("taskID", entry.TaskID))
+			errs = multierr.Append(errs, fmt.Errorf("failed to close %s: %v", entry.TaskID, err))
+			continue
+		}
+		pc.logger.Info("closed task", zap.String("owner", entry.Owner), zap.String("taskID", entry.TaskID))
+
+		entry.Status = entryRolledBack
+		if err := manifest.save(); err != nil {
+			return multierr.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (pc *phabBulkRollbackCommand) closeTask(entry *bulkRunEntry) error {
+	// maniphest.edit takes the object name as well for manifests missing the PHID
+	identifier := entry.TaskPHID
+	if identifier == "" {
+		identifier = entry.TaskID
+	}
+	req := phab.ManiphestEditRequest{
+		ObjectIdentifier: identifier,
+		Transactions: []phab.EditTransaction{
+			{Type: phab.TransactionStatus, Value: pc.Status},
+		},
+	}
+	if pc.Comment != "" {
+		req.Transactions = append(req.Transactions, phab.EditTransaction{Type: phab.TransactionComment, Value: pc.Comment})
+	}
+	var res phab.ManiphestEditResponse
+	return pc.client.Call("maniphest.edit", &req, &res)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etcinit/gonduit/test/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBulkRollback(t *testing.T) {
+	tests := []struct {
+		msg           string
+		actuallyClose bool
+		wantStatuses  []string
+	}{
+		{
+			msg:          "dry run leaves the manifest alone",
+			wantStatuses: []string{entryCreated, entryExisting, entryFailed},
+		},
+		{
+			msg:           "only created tasks are closed",
+			actuallyClose: true,
+			wantStatuses:  []string{entryRolledBack, entryExisting, entryFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := server.New()
+			defer s.Close()
+			s.RegisterCapabilities()
+			s.RegisterMethod("maniphest.edit", http.StatusOK, map[string]interface{}{
+				"result": map[string]interface{}{"object": map[string]interface{}{"id": 1, "phid": "PHID-TASK-1"}},
+			})
+
+			dir, err := ioutil.TempDir("", "bulk-rollback")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			manifest := &bulkRunManifest{
+				path: filepath.Join(dir, "config.run.json"),
+				Entries: []*bulkRunEntry{
+					{Owner: "alice", Status: entryCreated, TaskID: "T1", TaskPHID: "PHID-TASK-1"},
+					{Owner: "bob", Status: entryExisting, TaskID: "T2", TaskPHID: "PHID-TASK-2"},
+					{Owner: "carol", Status: entryFailed},
+				},
+			}
+			require.NoError(t, manifest.save())
+
+			cmd, ok := newPhabBulkRollbackCommand(&options{}, zap.NewNop()).(*phabBulkRollbackCommand)
+			require.True(t, ok, "conversion to phabBulkRollbackCommand failed")
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.Manifest = manifest.path
+			cmd.Status = "invalid"
+			cmd.Comment = "created by mistake"
+			cmd.ActuallyClose = tt.actuallyClose
+
+			require.NoError(t, cmd.Execute(nil /* args */))
+
+			got, err := readBulkRunManifest(manifest.path)
+			require.NoError(t, err)
+			var statuses []string
+			for _, entry := range got.Entries {
+				statuses = append(statuses, entry.Status)
+			}
+			assert.Equal(t, tt.wantStatuses, statuses)
+		})
+	}
+}
+
+func TestBulkRollbackNoManifest(t *testing.T) {
+	cmd := newPhabBulkRollbackCommand(&options{}, zap.NewNop())
+	assert.Equal(t, errNoManifest, cmd.Execute(nil /* args */))
+}
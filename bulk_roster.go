@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the roster columns and keys filling in an entry, every other one is a template var
+const (
+	rosterOwner    = "owner"
+	rosterProjects = "projects"
+	rosterCCUsers  = "ccusers"
+)
+
+// loadRoster reads the entries of a roster file. A .csv roster needs a header
+// row, any other file is read as one json object per line. Lists in a csv cell
+// or a json string are separated by commas or semicolons. Other columns have to
+// be one of the vars so a misspelled column is not quietly made a template var.
+func loadRoster(path string, vars map[string]interface{}) ([]emailConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open roster")
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readCSVRoster(f, vars)
+	}
+	return readJSONRoster(f, vars)
+}
+
+func readCSVRoster(r io.Reader, vars map[string]interface{}) ([]emailConfig, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read csv roster")
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := make([]string, len(rows[0]))
+	for col, name := range rows[0] {
+		header[col] = strings.TrimSpace(name)
+	}
+	var entries []emailConfig
+	for i, row := range rows[1:] {
+		fields := make(map[string]interface{}, len(row))
+		for col, value := range row {
+			fields[header[col]] = value
+		}
+		entry, err := rosterEntry(fields, vars, func(v interface{}) ([]string, error) {
+			return splitRosterList(fmt.Sprint(v)), nil
+		})
+		if err != nil {
+			// the header is line 1
+			return nil, errors.Wrapf(err, "line %d", i+2)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// splitRosterList splits a csv cell into names, nil when it is empty. Names
+// keep their inner spaces so projects like "Site Reliability" stay whole.
+func splitRosterList(list string) []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ';' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonRosterList reads a json list of names, a string is split like a csv cell.
+func jsonRosterList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return splitRosterList(v), nil
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, name := range v {
+			names = append(names, fmt.Sprint(name))
+		}
+		return names, nil
+	}
+	return nil, errors.Errorf("%v is not a list of names", v)
+}
+
+func readJSONRoster(r io.Reader, vars map[string]interface{}) ([]emailConfig, error) {
+	var entries []emailConfig
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		entry, err := rosterEntry(fields, vars, jsonRosterList)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errors.Wrap(scanner.Err(), "failed to read json roster")
+}
+
+// rosterEntry turns the fields of a roster row into an entry, list turns a
+// projects or cc users field into names. Every other field has to be one of vars.
+func rosterEntry(fields map[string]interface{}, vars map[string]interface{}, list func(interface{}) ([]string, error)) (emailConfig, error) {
+	entry := emailConfig{Vars: make(map[string]interface{})}
+	var err error
+	for key, value := range fields {
+		switch strings.ToLower(strings.Replace(key, "_", "", -1)) {
+		case rosterOwner:
+			entry.Owner = strings.TrimSpace(fmt.Sprint(value))
+		case rosterProjects:
+			entry.Projects, err = list(value)
+		case rosterCCUsers:
+			entry.CCUsers, err = list(value)
+		default:
+			if _, ok := vars[key]; !ok {
+				return emailConfig{}, errors.Errorf("unknown roster column %q, add it to the defaultVars of the config", key)
+			}
+			entry.Vars[key] = value
+		}
+		if err != nil {
+			return emailConfig{}, errors.Wrap(err, key)
+		}
+	}
+	if entry.Owner == "" {
+		return emailConfig{}, errors.New("roster entry without an owner")
+	}
+	return entry, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoster(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		give    string
+		want    []emailConfig
+		wantErr string
+	}{
+		{
+			name: "csv",
+			file: "roster.csv",
+			give: "owner,projects,cc_users,team\n" +
+				"alice,ops,\"bean,bob\",infra\n" +
+				"bob,,carol; bean,\n" +
+				"carol,\"Site Reliability; ops ,\",,sre\n",
+			want: []emailConfig{
+				{Owner: "alice", Projects: []string{"ops"}, CCUsers: []string{"bean", "bob"}, Vars: map[string]interface{}{"team": "infra"}},
+				{Owner: "bob", CCUsers: []string{"carol", "bean"}, Vars: map[string]interface{}{"team": ""}},
+				{Owner: "carol", Projects: []string{"Site Reliability", "ops"}, Vars: map[string]interface{}{"team": "sre"}},
+			},
+		},
+		{
+			name: "csv header with spaces",
+			file: "roster.csv",
+			give: " owner , team \nalice,infra\n",
+			want: []emailConfig{{Owner: "alice", Vars: map[string]interface{}{"team": "infra"}}},
+		},
+		{
+			name:    "csv unknown column",
+			file:    "roster.csv",
+			give:    "owner,projets\nalice,ops\n",
+			wantErr: `line 2: unknown roster column "projets", add it to the defaultVars of the config`,
+		},
+		{
+			name:    "csv without owner",
+			file:    "roster.csv",
+			give:    "owner,team\nalice,ops\n,infra\n",
+			wantErr: "line 3: roster entry without an owner",
+		},
+		{
+			name: "json lines",
+			file: "roster.jsonl",
+			give: `{"owner": "alice", "projects": ["ops"], "ccUsers": ["bean"], "team": "infra"}` + "\n\n" +
+				`{"owner": "bob", "count": 2}` + "\n",
+			want: []emailConfig{
+				{Owner: "alice", Projects: []string{"ops"}, CCUsers: []string{"bean"}, Vars: map[string]interface{}{"team": "infra"}},
+				{Owner: "bob", Vars: map[string]interface{}{"count": float64(2)}},
+			},
+		},
+		{
+			name: "json lines string lists",
+			file: "roster.jsonl",
+			give: `{"owner": "alice", "projects": "ops; Site Reliability", "ccUsers": "bob"}` + "\n",
+			want: []emailConfig{
+				{Owner: "alice", Projects: []string{"ops", "Site Reliability"}, CCUsers: []string{"bob"}, Vars: map[string]interface{}{}},
+			},
+		},
+		{
+			name:    "json lines not a list",
+			file:    "roster.jsonl",
+			give:    `{"owner": "alice", "projects": 3}` + "\n",
+			wantErr: "line 1: projects: 3 is not a list of names",
+		},
+		{
+			name:    "json lines broken",
+			file:    "roster.jsonl",
+			give:    `{"owner": "alice"}` + "\n" + `{"owner": ` + "\n",
+			wantErr: "line 2: unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bulk-roster")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, tt.file)
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.give), 0644))
+
+			got, err := loadRoster(path, map[string]interface{}{"team": "", "count": 0})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// templateFuncs are the helpers available to the title and description templates.
+var templateFuncs = template.FuncMap{
+	"join":    templateJoin,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"mention": func(user string) string { return "@" + strings.TrimPrefix(user, "@") },
+	"projectTag": func(project string) string {
+		return "#" + strings.Replace(strings.TrimPrefix(project, "#"), " ", "_", -1)
+	},
+	"taskLink": templateTaskLink,
+	"now":      time.Now,
+	"addDays":  templateAddDays,
+	"date":     templateDate,
+	"default":  templateDefault,
+	"indent":   templateIndent,
+}
+
+// templateJoin joins the items of a list, {{ .Projects | join ", " }}.
+func templateJoin(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join expects a list, got %T", list)
+	}
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep), nil
+}
+
+// templateTaskLink renders a task id as the remarkup reference to the task,
+// 123, "123" and "T123" all become T123.
+func templateTaskLink(id interface{}) string {
+	return "T" + strings.TrimPrefix(fmt.Sprint(id), "T")
+}
+
+// templateTime converts a time or a 2006-01-02 formatted date.
+func templateTime(t interface{}) (time.Time, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return time.ParseInLocation(dueDateLayout, t, time.Local)
+	}
+	return time.Time{}, fmt.Errorf("expected a time or a date, got %T", t)
+}
+
+// templateAddDays adds days to a time or date, {{ now | addDays 14 }}.
+func templateAddDays(days int, t interface{}) (time.Time, error) {
+	tt, err := templateTime(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tt.AddDate(0, 0, days), nil
+}
+
+// templateDate formats a time or date with a go layout, {{ now | date "Jan 2" }}.
+func templateDate(layout string, t interface{}) (string, error) {
+	tt, err := templateTime(t)
+	if err != nil {
+		return "", err
+	}
+	return tt.Format(layout), nil
+}
+
+// templateDefault returns value unless it is empty, {{ default "us" (index .Vars "team") }}.
+// Vars that may be missing have to go through index, {{ .Vars.team }} fails on a missing key.
+func templateDefault(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// templateIndent indents every line of s by spaces, handy for remarkup lists and quotes.
+func templateIndent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// bulkTemplates are the parsed title and description templates of a config.
+// Both are parsed once per run and share the partials of the template dir.
+type bulkTemplates struct {
+	title       *template.Template
+	description *template.Template
+}
+
+// loadTemplates parses the title and description templates of the config,
+// either inlined or read from files relative to configDir. Every *.tmpl file
+// of the template dir is a partial usable as {{ template "name" . }}.
+// Referencing a missing key fails the template instead of rendering "<no value>".
+func loadTemplates(conf yamlConfig, configDir string) (*bulkTemplates, error) {
+	partials := template.New("partials").Option("missingkey=error").Funcs(templateFuncs)
+	if conf.TemplateDir != "" {
+		files, err := filepath.Glob(filepath.Join(relativeTo(configDir, conf.TemplateDir), "*.tmpl"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list template dir")
+		}
+		for _, file := range files {
+			text, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read partial")
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+			if _, err := partials.New(name).Parse(string(text)); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse partial %q", name)
+			}
+		}
+	}
+
+	descriptionText, err := templateText(configDir, conf.TaskTemplate, conf.TaskTemplateFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read description template")
+	}
+	titleText, err := templateText(configDir, conf.TitleTemplate, conf.TitleTemplateFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read title template")
+	}
+
+	t := &bulkTemplates{}
+	if t.description, err = parseWithPartials(partials, "description", descriptionText); err != nil {
+		return nil, errors.Wrap(err, "failed to parse description template")
+	}
+	if t.title, err = parseWithPartials(partials, "title", titleText); err != nil {
+		return nil, errors.Wrap(err, "failed to parse title template")
+	}
+	return t, nil
+}
+
+// parseWithPartials parses text as a new template next to a copy of the partials.
+func parseWithPartials(partials *template.Template, name, text string) (*template.Template, error) {
+	t, err := partials.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.New(name).Parse(text)
+}
+
+// templateText returns the inlined template or the contents of its file.
+func templateText(configDir, inline, file string) (string, error) {
+	if file == "" {
+		return inline, nil
+	}
+	if inline != "" {
+		return "", fmt.Errorf("both an inlined template and the file %q are set", file)
+	}
+	text, err := ioutil.ReadFile(relativeTo(configDir, file))
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// relativeTo returns path relative to dir unless it is absolute.
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
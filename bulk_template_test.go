@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"projects": []string{"team a", "ops"},
+		"vars":     []interface{}{"x", 1},
+		"empty":    "",
+		"start":    "2018-06-01",
+		"body":     "one\ntwo",
+	}
+	tests := []struct {
+		give    string
+		want    string
+		wantErr bool
+	}{
+		{give: `{{ .projects | join ", " }}`, want: "team a, ops"},
+		{give: `{{ .vars | join "-" }}`, want: "x-1"},
+		{give: `{{ .empty | join "-" }}`, wantErr: true},
+		{give: `{{ "Bean" | upper }} {{ "Bean" | lower }}`, want: "BEAN bean"},
+		{give: `{{ mention "bean" }} {{ mention "@bean" }}`, want: "@bean @bean"},
+		{give: `{{ range .projects }}{{ projectTag . }} {{ end }}`, want: "#team_a #ops "},
+		{give: `{{ taskLink 12 }} {{ taskLink "T12" }}`, want: "T12 T12"},
+		{give: `{{ .start | addDays 30 | date "Jan 2 2006" }}`, want: "Jul 1 2018"},
+		{give: `{{ .start | date "Monday" }}`, want: "Friday"},
+		{give: `{{ "soon" | date "Monday" }}`, wantErr: true},
+		{give: `{{ .empty | default "none" }} {{ .start | default "none" }}`, want: "none 2018-06-01"},
+		{give: `{{ .body | indent 2 }}`, want: "  one\n  two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.give, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(templateFuncs).Parse(tt.give)
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			err = tmpl.Execute(&out, data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bulk-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "partials"), 0755))
+	files := map[string]string{
+		"title.tmpl":           "Hello {{ .Owner }}\n",
+		"description.tmpl":     `Hi {{ .Owner }}, {{ template "ask" . }}{{ template "footer" }}`,
+		"partials/ask.tmpl":    `please do {{ .InsertHere }}.`,
+		"partials/footer.tmpl": "\n\nThanks, the team",
+		"partials/ignored.txt": `{{ template "missing" }}`,
+	}
+	for name, text := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644))
+	}
+
+	conf := yamlConfig{
+		TitleTemplate:    `{{ template "ask" . }}`,
+		TaskTemplateFile: "description.tmpl",
+		TemplateDir:      "partials",
+	}
+	templates, err := loadTemplates(conf, dir)
+	require.NoError(t, err)
+
+	p := createTaskParams{
+		templates: templates,
+		emailConf: emailConfig{Owner: "bean", InsertHere: "the thing"},
+		resolved:  &resolvedConfig{},
+	}
+	title, description, err := p.render()
+	require.NoError(t, err)
+	assert.Equal(t, "please do the thing.", title)
+	assert.Equal(t, "Hi bean, please do the thing.\n\nThanks, the team", description)
+
+	conf.TitleTemplate = `{{ default "us" (index .Vars "team") }} {{ .Vars.size | default "small" }}`
+	templates, err = loadTemplates(conf, dir)
+	require.NoError(t, err)
+	p.templates = templates
+	p.emailConf.Vars = map[string]interface{}{"size": ""}
+	title, _, err = p.render()
+	require.NoError(t, err)
+	assert.Equal(t, "us small", title)
+
+	conf.TitleTemplate = `{{ .Vars.team | default "us" }}`
+	templates, err = loadTemplates(conf, dir)
+	require.NoError(t, err)
+	p.templates = templates
+	_, _, err = p.render()
+	assert.Error(t, err, "a missing var outside of index fails the template")
+
+	conf.TitleTemplate = ""
+	conf.TitleTemplateFile = "title.tmpl"
+	templates, err = loadTemplates(conf, dir)
+	require.NoError(t, err)
+	p.templates = templates
+	title, _, err = p.render()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello bean", title, "the newline ending the file is not part of the title")
+
+	conf.TitleTemplate = `{{ template "ask" . }}`
+	conf.TitleTemplateFile = ""
+	conf.TaskTemplate = "inlined"
+	_, err = loadTemplates(conf, dir)
+	assert.EqualError(t, err, `failed to read description template: both an inlined template and the file "description.tmpl" are set`)
+
+	conf.TaskTemplate = ""
+	conf.TaskTemplateFile = "missing.tmpl"
+	_, err = loadTemplates(conf, dir)
+	assert.Error(t, err)
+}
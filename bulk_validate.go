@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxCCUsers is the number of cc'd users after which a task gets noisy for everyone.
+const maxCCUsers = 30
+
+// entryReport is the preflight result of a single config entry.
+type entryReport struct {
+	owner       string
+	title       string
+	description string
+	projects    int
+	ccUsers     int
+	problems    []string
+}
+
+// validate renders every entry and checks it could be created without
+// creating anything, printing a table of what would be created. An error is
+// returned when any entry has a problem.
+func (pc *phabBulkCreateCommand) validate(conf yamlConfig) error {
+	resolved, err := pc.resolveConfig(conf)
+	if resolved == nil {
+		return err
+	}
+
+	var problems []string
+	templates, err := loadTemplates(conf, filepath.Dir(pc.EmailConfig))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if u := conf.Umbrella; u != nil {
+		if _, ok := resolved.users[u.Owner]; !ok {
+			problems = append(problems, fmt.Sprintf("umbrella: unknown owner %q", u.Owner))
+		}
+		if _, err := loadUmbrellaTemplates(conf, filepath.Dir(pc.EmailConfig)); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, name := range parentTaskNames(conf) {
+		if resolved.tasks[name] == "" {
+			problems = append(problems, fmt.Sprintf("unknown parent task %q", name))
+		}
+	}
+
+	var reports []entryReport
+	for _, e := range conf.Emails {
+		r := entryReport{owner: e.Owner}
+		if _, ok := resolved.users[e.Owner]; !ok {
+			r.problems = append(r.problems, fmt.Sprintf("unknown owner %q", e.Owner))
+		}
+		for _, name := range append(append([]string{}, conf.CommonCCUsers...), e.CCUsers...) {
+			if _, ok := resolved.users[name]; !ok {
+				r.problems = append(r.problems, fmt.Sprintf("unknown cc user %q", name))
+			}
+		}
+		for _, name := range append(append([]string{}, conf.CommonProjects...), e.Projects...) {
+			if _, ok := resolved.projects[name]; !ok {
+				r.problems = append(r.problems, fmt.Sprintf("unknown project %q", name))
+			}
+		}
+
+		r.projects = len(resolved.entryProjects(conf, e))
+		r.ccUsers = len(resolved.entryCCUsers(conf, e))
+		if r.projects == 0 {
+			r.problems = append(r.problems, errNoProjectSpecified.Error())
+		}
+		if r.ccUsers == 0 {
+			r.problems = append(r.problems, errNoUsersSpecified.Error())
+		}
+		if r.ccUsers > maxCCUsers {
+			r.problems = append(r.problems, fmt.Sprintf("more than %d users cc'd: %d", maxCCUsers, r.ccUsers))
+		}
+
+		fields := conf.entryFields(e)
+		if _, err := fields.priority(); err != nil {
+			r.problems = append(r.problems, err.Error())
+		}
+		if _, err := fields.auxiliary(); err != nil {
+			r.problems = append(r.problems, err.Error())
+		}
+
+		if templates != nil {
+			p := createTaskParams{
+				templates: templates,
+				conf:      conf,
+				emailConf: e,
+				resolved:  resolved,
+			}
+			if r.title, r.description, err = p.render(); err != nil {
+				r.problems = append(r.problems, err.Error())
+			}
+		}
+		reports = append(reports, r)
+	}
+
+	w := tabwriter.NewWriter(pc.output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tOWNER\tPROJECTS\tCC\tTITLE\tDESCRIPTION\tOK")
+	for i, r := range reports {
+		ok := "yes"
+		if len(r.problems) > 0 {
+			ok = "no"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%s\t%s\t%s\n", i, r.owner, r.projects, r.ccUsers, r.title, firstLine(r.description, 40), ok)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for i, r := range reports {
+		for _, problem := range r.problems {
+			problems = append(problems, fmt.Sprintf("entry %d (%s): %s", i, r.owner, problem))
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(pc.output, "\nall %d entries are valid\n", len(reports))
+		return nil
+	}
+	fmt.Fprintf(pc.output, "\n%d problems found:\n", len(problems))
+	for _, problem := range problems {
+		fmt.Fprintf(pc.output, "  %s\n", problem)
+	}
+	return fmt.Errorf("validation failed with %d problems", len(problems))
+}
+
+// firstLine returns the first line of s cut down to max characters.
+func firstLine(s string, max int) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if r := []rune(s); len(r) > max {
+		return string(r[:max-3]) + "..."
+	}
+	return s
+}
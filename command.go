@@ -1,6 +1,8 @@
 package main
 
 import (
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/core"
 	flags "github.com/jessevdk/go-flags"
 	"go.uber.org/zap"
 )
@@ -46,3 +48,16 @@ func (c baseCommand) ShortDescription() string {
 func (c baseCommand) LongDescription() string {
 	return c.longDesc
 }
+
+// dialPhab connects to the conduit API of a phab server.
+func dialPhab(uri, apiToken string) (*gonduit.Conn, error) {
+	if len(apiToken) == 0 {
+		return nil, errNoAPIToken
+	}
+	return gonduit.Dial(
+		uri,
+		&core.ClientOptions{
+			APIToken: apiToken,
+		},
+	)
+}
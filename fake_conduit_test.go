@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/responses"
+	"github.com/etcinit/gonduit/test/server"
+	"github.com/stretchr/testify/require"
+)
+
+// newTaskServer returns a fake conduit server that knows the users, projects and
+// tasks the task commands look up. Every maniphest.query answers with all of
+// the tasks, so the tests filter through the commands themselves.
+func newTaskServer(users phab.UserQueryResponse, projects []entities.Project, tasks ...*entities.ManiphestTask) *server.Server {
+	s := server.New()
+	s.RegisterCapabilities()
+	s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{"result": users})
+
+	projectsByPHID := make(map[string]entities.Project, len(projects))
+	for _, project := range projects {
+		projectsByPHID[project.PHID] = project
+	}
+	s.RegisterMethod("project.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ProjectQueryResponse{Data: projectsByPHID},
+	})
+
+	names := make(responses.PHIDLookupResponse, len(tasks))
+	tasksByPHID := make(responses.ManiphestQueryResponse, len(tasks))
+	for _, task := range tasks {
+		names[task.ObjectName] = &entities.PHIDResult{Name: task.ObjectName, PHID: task.PHID}
+		tasksByPHID[task.PHID] = task
+	}
+	s.RegisterMethod("phid.lookup", http.StatusOK, map[string]interface{}{"result": names})
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": tasksByPHID})
+	return s
+}
+
+// recordingServer sits in front of the fake conduit server and keeps the params
+// of every call. The fake answers every call of a method the same, answer can
+// reply to a call itself with a conduit body instead.
+type recordingServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	calls  map[string][]json.RawMessage
+	answer func(method string, params json.RawMessage) (map[string]interface{}, bool)
+}
+
+func newRecordingServer(t *testing.T, s *server.Server) *recordingServer {
+	target, err := url.Parse(s.GetURL())
+	require.NoError(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	rs := &recordingServer{calls: make(map[string][]json.RawMessage)}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		form, err := url.ParseQuery(string(body))
+		require.NoError(t, err)
+		method, params := strings.TrimPrefix(r.URL.Path, "/api/"), json.RawMessage(form.Get("params"))
+
+		rs.mu.Lock()
+		rs.calls[method] = append(rs.calls[method], params)
+		answer := rs.answer
+		rs.mu.Unlock()
+		if answer != nil {
+			if res, ok := answer(method, params); ok {
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		proxy.ServeHTTP(w, r)
+	}))
+	return rs
+}
+
+// params returns the params of the calls to the method in the order they came in.
+func (rs *recordingServer) params(method string) []json.RawMessage {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]json.RawMessage{}, rs.calls[method]...)
+}
@@ -47,6 +47,11 @@ func main() {
 	commands := []command{
 		newPhabListCommand(&opts, logger),
 		newPhabBulkCreateCommand(&opts, logger),
+		newPhabBulkRollbackCommand(&opts, logger),
+		newPhabBulkEditCommand(&opts, logger),
+		newPhabNudgeCommand(&opts, logger),
+		newPhabStaleCommand(&opts, logger),
+		newPhabWorkloadCommand(&opts, logger),
 	}
 
 	for _, cmd := range commands {
@@ -56,8 +61,12 @@ func main() {
 	}
 
 	if _, err := parser.Parse(); err != nil {
-		if _, ok := errors.Cause(err).(*flags.Error); ok {
+		flagsErr, ok := errors.Cause(err).(*flags.Error)
+		if ok {
 			parser.WriteHelp(os.Stdout)
 		}
+		if !ok || flagsErr.Type != flags.ErrHelp {
+			os.Exit(1)
+		}
 	}
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+const defaultNudgeMessage = `{{ mention .Owner.UserName }} this task has not been updated in {{ .IdleDays }} days, is it still being worked on?`
+
+// closed tasks are not waiting on anyone, nudging them only makes noise
+var errNudgeOpenOnly = errors.New("only open tasks can be nudged, --status has to be open")
+
+type phabNudgeCommand struct {
+	baseCommand
+
+	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
+	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
+
+	taskSelection
+
+	IdleDays int `long:"idle-days" description:"Only nudge tasks not modified for at least this many days" default:"14"`
+
+	Message     string `long:"message" description:"The comment template, executed with .Task, .Owner and .IdleDays"`
+	MessageFile string `long:"message-file" description:"A file holding the comment template"`
+
+	ActuallyComment bool `long:"actually-comment" description:"The default action is to dry run and only show the comment for every task."`
+
+	output io.Writer
+	// The phab conduit client for the command to share the client session
+	client *gonduit.Conn
+	// now is the time the idle days are counted from
+	now func() time.Time
+}
+
+func newPhabNudgeCommand(opts *options, logger *zap.Logger) command {
+	return &phabNudgeCommand{
+		baseCommand: newBaseCommand(
+			"phab-nudge",
+			"Comment on the tasks that have not moved in a while.",
+			"Ping the owners of open tasks that have not been modified for a while with a comment rendered from a template.",
+			opts, logger),
+		output: os.Stdout,
+		now:    time.Now,
+	}
+}
+
+// nudgeData is what the message template is executed with.
+type nudgeData struct {
+	Task  *entities.ManiphestTask
+	Owner phab.User
+	// IdleDays are the days since the task was last modified.
+	IdleDays int
+}
+
+func (pc *phabNudgeCommand) Execute(_ []string) error {
+	if pc.taskSelection.empty() {
+		return errNoTasksSelected
+	}
+	if pc.Status != "" && pc.Status != "open" {
+		return errNudgeOpenOnly
+	}
+	message, err := pc.messageTemplate()
+	if err != nil {
+		return err
+	}
+
+	client, err := dialPhab(pc.PhabURI, pc.PhabAPIToken)
+	if err != nil {
+		return err
+	}
+	pc.client = client
+
+	tasks, err := pc.selectTasks(pc.baseCommand, pc.client)
+	if err != nil {
+		return err
+	}
+	var idle []*entities.ManiphestTask
+	var ownerPHIDs []string
+	for _, task := range tasks {
+		if task.OwnerPHID == "" {
+			pc.logger.Info("skipping task without an owner", zap.String("taskID", task.ObjectName))
+			continue
+		}
+		if daysSince(pc.now(), task.DateModified) < pc.IdleDays {
+			continue
+		}
+		idle = append(idle, task)
+		ownerPHIDs = append(ownerPHIDs, task.OwnerPHID)
+	}
+	owners, err := getPhabUsersByPHID(pc.client, uniqueStrings(ownerPHIDs))
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, task := range idle {
+		buf := &bytes.Buffer{}
+		data := nudgeData{Task: task, Owner: owners[task.OwnerPHID], IdleDays: daysSince(pc.now(), task.DateModified)}
+		if err := message.Execute(buf, data); err != nil {
+			errs = multierr.Append(errs, errors.Wrapf(err, "failed to render the message for %s", task.ObjectName))
+			continue
+		}
+
+		fmt.Fprintf(pc.output, "%s %s\n%s\n\n", task.ObjectName, task.Title, templateIndent(2, buf.String()))
+		if !pc.ActuallyComment {
+			continue
+		}
+
+		req := phab.ManiphestEditRequest{
+			ObjectIdentifier: task.PHID,
+			Transactions: []phab.EditTransaction{
+				{Type: phab.TransactionComment, Value: buf.String()},
+			},
+		}
+		var res phab.ManiphestEditResponse
+		if err := pc.client.Call("maniphest.edit", &req, &res); err != nil {
+			pc.logger.Error("failed to comment on task", zap.Error(err), zap.String("taskID", task.ObjectName))
+			errs = multierr.Append(errs, fmt.Errorf("failed to comment on %s: %v", task.ObjectName, err))
+			continue
+		}
+		pc.logger.Info("nudged task", zap.String("taskID", task.ObjectName), zap.String("owner", data.Owner.UserName))
+	}
+	if !pc.ActuallyComment {
+		pc.logger.Info("DRY RUN, no comment was posted", zap.Int("tasks", len(idle)))
+	}
+	return errs
+}
+
+// messageTemplate parses the message template of the flags.
+func (pc *phabNudgeCommand) messageTemplate() (*template.Template, error) {
+	text, err := templateText("", pc.Message, pc.MessageFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the message template")
+	}
+	if text == "" {
+		text = defaultNudgeMessage
+	}
+	t, err := template.New("message").Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	return t, errors.Wrap(err, "failed to parse the message template")
+}
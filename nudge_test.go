@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPhabNudge(t *testing.T) {
+	now := time.Date(2018, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) util.UnixTimestamp {
+		return util.UnixTimestamp(now.AddDate(0, 0, -days))
+	}
+
+	tests := []struct {
+		msg             string
+		message         string
+		status          string
+		actuallyComment bool
+		wantOut         string
+		wantErr         string
+	}{
+		{
+			msg: "default message",
+			wantOut: "T1 Stale\n" +
+				"  @alice this task has not been updated in 20 days, is it still being worked on?\n\n",
+		},
+		{
+			msg:     "custom message",
+			message: "{{ .Owner.RealName }}, {{ taskLink .Task.ID }} is {{ .Task.Status }}",
+			wantOut: "T1 Stale\n  Alice Liddell, T1 is open\n\n",
+		},
+		{
+			msg:             "comments are posted",
+			actuallyComment: true,
+			message:         "ping",
+			wantOut:         "T1 Stale\n  ping\n\n",
+		},
+		{
+			msg:     "closed tasks are not nudged",
+			status:  "resolved",
+			wantErr: errNudgeOpenOnly.Error(),
+		},
+		{
+			msg:     "broken message",
+			message: "{{ .Task.Missing }}",
+			wantErr: "failed to render the message for T1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := newTaskServer(
+				phab.UserQueryResponse{{UserName: "alice", RealName: "Alice Liddell", PHID: "PHID-USER-alice"}},
+				nil, /* projects */
+				&entities.ManiphestTask{
+					ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "Stale", Status: "open",
+					OwnerPHID: "PHID-USER-alice", DateModified: daysAgo(20),
+				},
+				&entities.ManiphestTask{
+					ID: "2", PHID: "PHID-TASK-2", ObjectName: "T2", Title: "Fresh", Status: "open",
+					OwnerPHID: "PHID-USER-alice", DateModified: daysAgo(2),
+				},
+				&entities.ManiphestTask{
+					ID: "3", PHID: "PHID-TASK-3", ObjectName: "T3", Title: "Nobody", Status: "open",
+					DateModified: daysAgo(30),
+				},
+			)
+			defer s.Close()
+			s.RegisterMethod("maniphest.edit", http.StatusOK, map[string]interface{}{"result": phab.ManiphestEditResponse{}})
+
+			cmd, ok := newPhabNudgeCommand(&options{}, zap.NewNop()).(*phabNudgeCommand)
+			require.True(t, ok, "conversion to phabNudgeCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.now = func() time.Time { return now }
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.Tasks = "T1,T2,T3"
+			cmd.IdleDays = 14
+			cmd.Message = tt.message
+			cmd.Status = tt.status
+			cmd.ActuallyComment = tt.actuallyComment
+
+			err := cmd.Execute(nil /* args */)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOut, out.String())
+		})
+	}
+}
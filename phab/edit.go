@@ -0,0 +1,40 @@
+package phab
+
+import (
+	"github.com/etcinit/gonduit/requests"
+)
+
+// Transaction types of maniphest.edit used by the commands.
+const (
+	TransactionStatus         = "status"
+	TransactionComment        = "comment"
+	TransactionOwner          = "owner"
+	TransactionPriority       = "priority"
+	TransactionProjectsAdd    = "projects.add"
+	TransactionProjectsRemove = "projects.remove"
+	TransactionSubscribersAdd = "subscribers.add"
+	TransactionParentsAdd     = "parents.add"
+	TransactionSubtype        = "subtype"
+)
+
+// ManiphestEditRequest represents a request to maniphest.edit.
+type ManiphestEditRequest struct {
+	ObjectIdentifier string            `json:"objectIdentifier,omitempty"`
+	Transactions     []EditTransaction `json:"transactions"`
+	requests.Request                   // Includes __conduit__ field needed for authentication.
+}
+
+type EditTransaction struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type ManiphestEditResponse struct {
+	Object struct {
+		ID   int    `json:"id"`
+		PHID string `json:"phid"`
+	} `json:"object"`
+	Transactions []struct {
+		PHID string `json:"phid"`
+	} `json:"transactions"`
+}
@@ -0,0 +1,64 @@
+package phab
+
+// TaskNode is the serializable form of a TaskTree used for structured output.
+type TaskNode struct {
+	ID        string      `json:"id" yaml:"id"`
+	PHID      string      `json:"phid" yaml:"phid"`
+	Name      string      `json:"name" yaml:"name"`
+	Title     string      `json:"title" yaml:"title"`
+	Status    string      `json:"status" yaml:"status"`
+	Priority  string      `json:"priority" yaml:"priority"`
+	OwnerPHID string      `json:"ownerPHID,omitempty" yaml:"ownerPHID,omitempty"`
+	Owner     string      `json:"owner,omitempty" yaml:"owner,omitempty"`
+	CCs       []string    `json:"ccs,omitempty" yaml:"ccs,omitempty"`
+	Projects  []string    `json:"projects,omitempty" yaml:"projects,omitempty"`
+	URI       string      `json:"uri,omitempty" yaml:"uri,omitempty"`
+	Reverse   bool        `json:"reverse,omitempty" yaml:"reverse,omitempty"`
+	Cycle     bool        `json:"cycle,omitempty" yaml:"cycle,omitempty"`
+	Shared    bool        `json:"shared,omitempty" yaml:"shared,omitempty"`
+	Items     []*TaskNode `json:"items,omitempty" yaml:"items,omitempty"`
+}
+
+// NewTaskNode converts a task tree and all its children into TaskNodes.
+func NewTaskNode(t *TaskTree) *TaskNode {
+	n := &TaskNode{
+		ID:        t.ID,
+		PHID:      t.PHID,
+		Name:      t.ObjectName,
+		Title:     t.Title,
+		Status:    t.Status,
+		Priority:  t.Priority,
+		OwnerPHID: t.OwnerPHID,
+		Owner:     t.OwnerName,
+		CCs:       t.CCNames,
+		Projects:  t.ProjectNames,
+		URI:       t.URI,
+		Reverse:   t.Reverse,
+		Cycle:     t.Cycle,
+		Shared:    t.Shared,
+	}
+	for _, item := range t.Items {
+		n.Items = append(n.Items, NewTaskNode(item))
+	}
+	return n
+}
+
+// FlatTask is a single task of a flattened tree along with its position in it.
+type FlatTask struct {
+	*TaskNode
+	Parent string
+	Depth  int
+}
+
+// Flatten walks the tree depth first returning every task with the name of its parent.
+func Flatten(n *TaskNode) []FlatTask {
+	return flatten(n, "", 0)
+}
+
+func flatten(n *TaskNode, parent string, depth int) []FlatTask {
+	result := []FlatTask{{TaskNode: n, Parent: parent, Depth: depth}}
+	for _, item := range n.Items {
+		result = append(result, flatten(item, n.Name, depth+1)...)
+	}
+	return result
+}
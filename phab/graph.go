@@ -0,0 +1,123 @@
+package phab
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// priorityColors maps the maniphest priority names to the colors phab uses for them.
+var priorityColors = map[string]string{
+	"unbreak now!": "#da49be",
+	"needs triage": "#8e44ad",
+	"high":         "#c0392b",
+	"normal":       "#e67e22",
+	"low":          "#f1c40f",
+	"wishlist":     "#3498db",
+}
+
+const defaultColor = "#95a5a6"
+
+// graph is the set of tasks and dependencies in a group of trees, each task
+// is only in it once no matter how many trees it shows up in.
+type graph struct {
+	nodes []*TaskTree
+	seen  map[string]bool
+	// edges go from a task to the task it depends on
+	edges     [][2]*TaskTree
+	seenEdges map[[2]string]bool
+}
+
+func newGraph(trees []*TaskTree) *graph {
+	g := &graph{
+		seen:      make(map[string]bool),
+		seenEdges: make(map[[2]string]bool),
+	}
+	for _, t := range trees {
+		g.walk(t, t.Reverse)
+	}
+	sort.Slice(g.nodes, func(i, j int) bool { return g.nodes[i].ID < g.nodes[j].ID })
+	return g
+}
+
+func (g *graph) walk(t *TaskTree, reverse bool) {
+	if !g.seen[t.PHID] {
+		g.seen[t.PHID] = true
+		g.nodes = append(g.nodes, t)
+	}
+	for _, item := range t.Items {
+		from, to := t, item
+		if reverse {
+			from, to = item, t
+		}
+		key := [2]string{from.PHID, to.PHID}
+		if !g.seenEdges[key] {
+			g.seenEdges[key] = true
+			g.edges = append(g.edges, [2]*TaskTree{from, to})
+		}
+		g.walk(item, reverse)
+	}
+}
+
+func priorityColor(t *TaskTree) string {
+	if color, ok := priorityColors[strings.ToLower(t.Priority)]; ok {
+		return color
+	}
+	return defaultColor
+}
+
+func closed(t *TaskTree) bool {
+	return t.Status != "" && t.Status != StatusOpen
+}
+
+// DOT returns the trees as a single graphviz digraph with an edge from every
+// task to the tasks it depends on. Nodes are filled in with the priority color
+// and closed tasks are dashed.
+func DOT(trees []*TaskTree) string {
+	g := newGraph(trees)
+
+	var b strings.Builder
+	b.WriteString("digraph tasks {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled, fontcolor=white];\n")
+	for _, t := range g.nodes {
+		style := "filled"
+		if closed(t) {
+			style = "filled,dashed"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, fillcolor=%q, style=%q];\n",
+			t.ObjectName, t.ObjectName+": "+stringTitle(t), priorityColor(t), style)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "  %q -> %q;\n", e[0].ObjectName, e[1].ObjectName)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns the trees as a mermaid flowchart, styled the same as DOT.
+func Mermaid(trees []*TaskTree) string {
+	g := newGraph(trees)
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, t := range g.nodes {
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", t.ObjectName, mermaidEscape(t.ObjectName+": "+stringTitle(t)))
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", e[0].ObjectName, e[1].ObjectName)
+	}
+	for _, t := range g.nodes {
+		style := fmt.Sprintf("fill:%s,color:#fff", priorityColor(t))
+		if closed(t) {
+			style += ",stroke-dasharray:5 5"
+		}
+		fmt.Fprintf(&b, "  style %s %s\n", t.ObjectName, style)
+	}
+	return b.String()
+}
+
+// mermaidEscape replaces the characters that end a mermaid label with entity codes.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
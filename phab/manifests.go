@@ -1,30 +1,94 @@
 package phab
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/requests"
 )
 
 type SearchConstaints struct {
-	Projects []string `json:"projects"`
+	Projects   []string `json:"projects,omitempty"`
+	SubtaskIDs []int    `json:"subtaskIDs,omitempty"`
 }
 
+// ManifestSearch represents a request to maniphest.search.
 type ManifestSearch struct {
-	Constraints SearchConstaints `json:"constraints"`
+	Constraints      SearchConstaints `json:"constraints"`
+	After            string           `json:"after,omitempty"`
+	requests.Request                  // Includes __conduit__ field needed for authentication.
 }
 
+type ManifestSearchResponse struct {
+	Data   []ManifestSearchResult `json:"data"`
+	Cursor SearchCursor           `json:"cursor"`
+}
+
+type ManifestSearchResult struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	PHID string `json:"phid"`
+}
+
+// SearchCursor is the paging information of a search, After is empty on the last page.
+type SearchCursor struct {
+	Limit int         `json:"limit"`
+	After json.Number `json:"after"`
+}
+
+// StatusOpen is the status of a task still being worked on.
+const StatusOpen = "open"
+
 type TaskTree struct {
 	*entities.ManiphestTask
 	Items []*TaskTree
+	// Cycle is set when the task is one of its own ancestors, Items is never filled in
+	Cycle bool
+	// Reverse is set on trees whose Items are the tasks depending on it
+	Reverse bool
+	// Shared is set when the task and its dependencies were already rendered earlier
+	Shared bool
+
+	// OwnerName, CCNames and ProjectNames are only filled in when they were
+	// looked up for the columns of the tree.
+	OwnerName    string
+	CCNames      []string
+	ProjectNames []string
 }
 
-func StringTree(t *TaskTree) (result string) {
-	result += fmt.Sprintf("%s: %s\n", t.ObjectName, t.Title)
+// The columns StringTreeColumns can show after every task.
+const (
+	ColumnOwner    = "owner"
+	ColumnCCs      = "ccs"
+	ColumnStatus   = "status"
+	ColumnProjects = "projects"
+	ColumnModified = "modified"
+)
+
+// Columns are all the known columns in the order they are shown.
+var Columns = []string{ColumnOwner, ColumnCCs, ColumnStatus, ColumnProjects, ColumnModified}
+
+func StringTree(t *TaskTree) string {
+	return StringTreeColumns(t, nil)
+}
+
+// StringTreeColumns renders the tree like StringTree with the columns added
+// after the title of every task.
+func StringTreeColumns(t *TaskTree, columns []string) (result string) {
+	if t.Shared {
+		return fmt.Sprintf("%s: %s (see above)\n", t.ObjectName, stringTitle(t))
+	}
+	if t.Reverse {
+		result += fmt.Sprintf("%s: %s%s (blocks)\n", t.ObjectName, stringTitle(t), stringColumns(t, columns))
+	} else {
+		result += fmt.Sprintf("%s: %s%s\n", t.ObjectName, stringTitle(t), stringColumns(t, columns))
+	}
 	var spaces []bool
-	result += stringObjItems(t.Items, spaces)
+	result += stringObjItems(t.Items, spaces, columns)
 	return result
 }
 
@@ -46,16 +110,72 @@ func stringLine(name string, spaces []bool, last bool) (result string) {
 	return
 }
 
-func stringObjItems(items []*TaskTree, spaces []bool) (result string) {
+func stringObjItems(items []*TaskTree, spaces []bool, columns []string) (result string) {
 	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
 
 	for i, f := range items {
 		last := (i >= len(items)-1)
-		result += stringLine(fmt.Sprintf("%s: %-6v - %s", f.ObjectName, strings.ToUpper(f.Priority), f.Title), spaces, last)
+		result += stringLine(stringTask(f, columns), spaces, last)
 		if len(f.Items) > 0 {
 			spacesChild := append(spaces, last)
-			result += stringObjItems(f.Items, spacesChild)
+			result += stringObjItems(f.Items, spacesChild, columns)
 		}
 	}
 	return
 }
+
+func stringTask(t *TaskTree, columns []string) string {
+	switch {
+	case t.Cycle:
+		return fmt.Sprintf("↻ %s (cycle)", t.ObjectName)
+	case t.Shared:
+		return fmt.Sprintf("↑ %s (see above)", t.ObjectName)
+	}
+	return fmt.Sprintf("%s: %-6v - %s%s", t.ObjectName, strings.ToUpper(t.Priority), stringTitle(t), stringColumns(t, columns))
+}
+
+// stringColumns returns the columns of the task separated by pipes, a dash
+// stands in for a column with nothing to show.
+func stringColumns(t *TaskTree, columns []string) string {
+	var result string
+	for _, column := range columns {
+		var value string
+		switch column {
+		case ColumnOwner:
+			if t.OwnerName != "" {
+				value = "@" + t.OwnerName
+			}
+		case ColumnCCs:
+			var ccs []string
+			for _, cc := range t.CCNames {
+				ccs = append(ccs, "@"+cc)
+			}
+			value = strings.Join(ccs, " ")
+		case ColumnStatus:
+			value = t.Status
+		case ColumnProjects:
+			var tags []string
+			for _, project := range t.ProjectNames {
+				tags = append(tags, "#"+strings.Replace(project, " ", "_", -1))
+			}
+			value = strings.Join(tags, " ")
+		case ColumnModified:
+			if modified := time.Time(t.DateModified); !modified.IsZero() && modified.Unix() != 0 {
+				value = modified.Format("2006-01-02")
+			}
+		}
+		if value == "" {
+			value = "-"
+		}
+		result += " | " + value
+	}
+	return result
+}
+
+// stringTitle adds the status to the title of tasks that are no longer open.
+func stringTitle(t *TaskTree) string {
+	if t.Status == "" || t.Status == StatusOpen {
+		return t.Title
+	}
+	return fmt.Sprintf("%s [%s]", t.Title, t.Status)
+}
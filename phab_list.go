@@ -5,7 +5,9 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jeffbean/inam/phab"
 
@@ -19,6 +21,15 @@ import (
 	"go.uber.org/zap"
 )
 
+// defaultBatchSize matches the default page size of maniphest.query
+const defaultBatchSize = 100
+
+const (
+	directionDown = "down"
+	directionUp   = "up"
+	directionBoth = "both"
+)
+
 var (
 	errNoDepTasksFound = errors.New("no dependency tasks found in the graph")
 	errNoAPIToken      = errors.New("an api token is required to run the phab command")
@@ -30,14 +41,32 @@ type phabCommand struct {
 	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
 	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
 
-	TasksByOwner string `long:"task_author" description:"query for phab tasks by owner"`
+	TasksByOwner string `long:"task_author" description:"Comma sep list of usernames to get all owned tasks from"`
 
 	Tasks    string `long:"tasks" description:"Comma sep List of tasks "`
 	Projects string `long:"projects" description:"Comma sep list of projects to get all tasks from"`
 
+	BatchSize   int `long:"batch-size" description:"The max number of tasks to fetch in a single query" default:"100"`
+	Concurrency int `long:"concurrency" description:"The max number of queries to run at the same time" default:"1"`
+
+	Status string `long:"status" description:"Only show tasks with the status, applies to every level of the tree" default:"open" choice:"open" choice:"closed" choice:"resolved" choice:"wontfix" choice:"invalid" choice:"any"`
+
+	Direction string `long:"direction" description:"Show what --tasks depend on, what depends on them or both" default:"down" choice:"down" choice:"up" choice:"both"`
+
+	Output string `long:"output" short:"o" description:"The format to write results in" default:"text" choice:"text" choice:"json" choice:"yaml" choice:"csv"`
+	Graph  string `long:"graph" description:"Write the task dependencies as a graph instead" choice:"dot" choice:"mermaid"`
+
+	Columns string `long:"columns" description:"Comma sep list of details to show after every task of the trees: owner, ccs, status, projects, modified"`
+
 	output io.Writer
 	// The phab conduit client for the command to share the client session
 	client *gonduit.Conn
+	// graph caches every task fetched during the run
+	graph *taskGraph
+	// columns are the parsed Columns
+	columns []string
+	// names caches the user and project names looked up for the columns by PHID
+	names map[string]string
 }
 
 func newPhabListCommand(opts *options, logger *zap.Logger) command {
@@ -55,6 +84,15 @@ func (pc *phabCommand) Execute(_ []string) error {
 	if len(pc.PhabAPIToken) == 0 {
 		return errNoAPIToken
 	}
+	columns, err := parseColumns(pc.Columns)
+	if err != nil {
+		return err
+	}
+	pc.columns = columns
+	renderer, err := newListRenderer(pc.Output, pc.Graph, pc.columns, pc.output)
+	if err != nil {
+		return err
+	}
 	// all actions in the conduit API need the PHID from the system
 	//   we can lookup the PHID based on the entity in the case of a task is in the form TXXXXX
 	client, err := gonduit.Dial(
@@ -67,6 +105,8 @@ func (pc *phabCommand) Execute(_ []string) error {
 		return err
 	}
 	pc.client = client
+	pc.graph = newTaskGraph()
+	pc.names = make(map[string]string)
 	var taskList []*entities.PHIDResult
 
 	if len(pc.Projects) > 0 {
@@ -80,11 +120,12 @@ func (pc *phabCommand) Execute(_ []string) error {
 		}
 
 		for projectName, p := range projects {
-			fmt.Fprintf(pc.output, "Project: %s\n", projectName)
+			renderer.Project(projectName)
 			pc.logger.Debug("project found", zap.Any("phid", p.PHID), zap.Any("name", p.Name))
 		}
 
 		if len(projects) > 0 {
+			pc.graph.newSection()
 			var projectLookup []string
 			// Now search for all manifests for the projects we found.
 			for _, project := range projects {
@@ -92,19 +133,54 @@ func (pc *phabCommand) Execute(_ []string) error {
 			}
 			tasks, err := pc.phabManiphestQueryTree(requests.ManiphestQueryRequest{
 				ProjectPHIDs: projectLookup,
-				Status:       "status-open",
+				Status:       pc.queryStatus(),
 			})
 			if err != nil {
 				return err
 			}
 			sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
-			for _, task := range tasks {
-				fmt.Fprintf(pc.output, phab.StringTree(task))
+			if err := pc.renderTrees(renderer, tasks); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(pc.TasksByOwner) > 0 {
+		owners := strings.Split(pc.TasksByOwner, ",")
+		users, err := getPhabUsers(pc.client, owners)
+		if users == nil {
+			return err
+		}
+		// Same as projects, log the users we could not find and show the rest
+		if err != nil {
+			pc.logger.Error("errors looking up owners", zap.Error(err))
+		}
+
+		for _, owner := range owners {
+			user, ok := users[owner]
+			if !ok {
+				continue
+			}
+			renderer.Owner(user.UserName)
+			pc.logger.Debug("owner found", zap.String("phid", user.PHID), zap.String("name", user.UserName))
+			pc.graph.newSection()
+
+			tasks, err := pc.phabManiphestQueryTree(requests.ManiphestQueryRequest{
+				OwnerPHIDs: []string{user.PHID},
+				Status:     pc.queryStatus(),
+			})
+			if err != nil {
+				return err
+			}
+			if err := pc.renderTrees(renderer, tasks); err != nil {
+				return err
 			}
 		}
 	}
 
 	if len(pc.Tasks) > 0 {
+		renderer.Selected()
+		pc.graph.newSection()
 		phids, err := pc.phabLookupPHIDByName(strings.Split(pc.Tasks, ","))
 		if err != nil {
 			return err
@@ -114,28 +190,136 @@ func (pc *phabCommand) Execute(_ []string) error {
 			// FIXME: this is showing the tree and the list of tasks -
 			// pick one and change this all around
 			if result.Type == "TASK" {
-				tasks, err := pc.phabManiphestQueryTree(requests.ManiphestQueryRequest{
+				roots, err := pc.phabManiphestQueryRoots(requests.ManiphestQueryRequest{
 					PHIDs:  []string{result.PHID},
-					Status: "status-open",
+					Status: pc.queryStatus(),
 				})
 				if err != nil {
 					return fmt.Errorf("failed to get task from phab ids: %v", err)
 				}
-				sort.Slice(tasks, func(i, j int) bool { return tasks[i].Status < tasks[j].Status })
-				for _, task := range tasks {
-					fmt.Fprintf(pc.output, phab.StringTree(task))
+				tasks, err := pc.taskTrees(roots, pc.Direction)
+				if err != nil {
+					return err
+				}
+				sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Status < tasks[j].Status })
+				if err := pc.renderTrees(renderer, tasks); err != nil {
+					return err
 				}
 			}
 		}
 
 		sort.Slice(taskList, func(i, j int) bool { return taskList[i].PHID < taskList[j].PHID })
 		for _, task := range taskList {
-			fmt.Fprintf(pc.output, "Task: %s - status: %s\n", task.Name, task.Status)
+			renderer.Task(task)
+		}
+	}
+	return renderer.Flush()
+}
+
+// parseColumns returns the columns of the comma sep list, failing on unknown ones.
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, column := range splitList(list) {
+		column = strings.TrimSpace(column)
+		if !containsString(phab.Columns, column) {
+			return nil, fmt.Errorf("unknown column %q, the columns are %s", column, strings.Join(phab.Columns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// renderTrees hands the trees to the renderer once the names of their columns are looked up.
+func (pc *phabCommand) renderTrees(renderer listRenderer, trees []*phab.TaskTree) error {
+	if err := pc.nameTrees(trees); err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		renderer.Tree(tree)
+	}
+	return nil
+}
+
+// nameTrees fills in the owner, cc and project names of every task of the
+// trees the columns need. Names are looked up in batches and cached for the run.
+func (pc *phabCommand) nameTrees(trees []*phab.TaskTree) error {
+	users := containsString(pc.columns, phab.ColumnOwner) || containsString(pc.columns, phab.ColumnCCs)
+	projects := containsString(pc.columns, phab.ColumnProjects)
+	if !users && !projects {
+		return nil
+	}
+
+	var userPHIDs, projectPHIDs []string
+	walkTrees(trees, func(t *phab.TaskTree) {
+		if users {
+			userPHIDs = append(userPHIDs, t.OwnerPHID)
+			userPHIDs = append(userPHIDs, t.CCPHIDs...)
+		}
+		if projects {
+			projectPHIDs = append(projectPHIDs, t.ProjectPHIDs...)
 		}
+	})
+	found, err := getPhabUsersByPHID(pc.client, pc.unnamed(userPHIDs))
+	if err != nil {
+		return errors.Wrap(err, "failed to look up the users of the tasks")
+	}
+	for phid, user := range found {
+		pc.names[phid] = user.UserName
 	}
+	projectNames, err := phabProjectNamesByPHID(pc.client, pc.unnamed(projectPHIDs))
+	if err != nil {
+		return errors.Wrap(err, "failed to look up the projects of the tasks")
+	}
+	for phid, name := range projectNames {
+		pc.names[phid] = name
+	}
+
+	walkTrees(trees, func(t *phab.TaskTree) {
+		t.OwnerName = pc.names[t.OwnerPHID]
+		t.CCNames = pc.namesOf(t.CCPHIDs)
+		t.ProjectNames = pc.namesOf(t.ProjectPHIDs)
+	})
 	return nil
 }
 
+// unnamed returns the PHIDs without a cached name.
+func (pc *phabCommand) unnamed(phids []string) []string {
+	var result []string
+	for _, phid := range uniqueStrings(phids) {
+		if _, ok := pc.names[phid]; !ok {
+			result = append(result, phid)
+		}
+	}
+	return result
+}
+
+// namesOf returns the cached names of the PHIDs, unknown ones are left out.
+func (pc *phabCommand) namesOf(phids []string) []string {
+	var names []string
+	for _, phid := range phids {
+		if name, ok := pc.names[phid]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// walkTrees calls fn for every task of the trees.
+func walkTrees(trees []*phab.TaskTree, fn func(*phab.TaskTree)) {
+	for _, t := range trees {
+		fn(t)
+		walkTrees(t.Items, fn)
+	}
+}
+
+// queryStatus returns the maniphest.query status filter for the status option.
+func (pc *phabCommand) queryStatus() string {
+	if pc.Status == "" {
+		return "status-open"
+	}
+	return "status-" + pc.Status
+}
+
 func (pc *phabCommand) phabLookupPHIDByName(tasks []string) (responses.PHIDLookupResponse, error) {
 	var err error
 
@@ -159,33 +343,210 @@ func (pc *phabCommand) phabLookupPHIDByName(tasks []string) (responses.PHIDLooku
 }
 
 func (pc *phabCommand) phabManiphestQueryTree(req requests.ManiphestQueryRequest) ([]*phab.TaskTree, error) {
-	// var errs []error
-	var items []*phab.TaskTree
+	roots, err := pc.phabManiphestQueryRoots(req)
+	if err != nil {
+		return nil, err
+	}
+	return pc.taskTrees(roots, directionDown)
+}
 
+// phabManiphestQueryRoots queries the tasks to build trees from and adds them to the graph.
+func (pc *phabCommand) phabManiphestQueryRoots(req requests.ManiphestQueryRequest) ([]*entities.ManiphestTask, error) {
 	res, err := pc.client.ManiphestQuery(req)
 	if err != nil {
 		return nil, err
 	}
 
+	var roots []*entities.ManiphestTask
 	for _, task := range *res {
-		child := &phab.TaskTree{ManiphestTask: task, Items: nil}
+		pc.graph.add(task)
+		roots = append(roots, task)
+	}
+	sortTasks(roots)
+	return roots, nil
+}
 
-		if len(task.DependsOnTaskPHIDs) > 0 {
-			pc.logger.Debug("task has dependant tasks", zap.Any("task", task.ObjectName), zap.Any("items", task.DependsOnTaskPHIDs))
-			newTasks, err := pc.phabManiphestQueryTree(requests.ManiphestQueryRequest{
-				PHIDs:  task.DependsOnTaskPHIDs,
-				Status: "status-open",
-			})
+// taskTrees builds a tree for every root in the given direction. Walking both
+// ways returns the dependency tree of each root followed by its reverse tree.
+func (pc *phabCommand) taskTrees(roots []*entities.ManiphestTask, direction string) ([]*phab.TaskTree, error) {
+	down := direction != directionUp
+	up := direction == directionUp || direction == directionBoth
+
+	if down {
+		if err := pc.fetchDependencies(roots); err != nil {
+			return nil, err
+		}
+	}
+	if up {
+		if err := pc.fetchParents(roots); err != nil {
+			return nil, err
+		}
+	}
+
+	var items []*phab.TaskTree
+	for _, task := range roots {
+		if down {
+			items = append(items, pc.graph.tree(task))
+		}
+		if up {
+			items = append(items, pc.graph.reverseTree(task))
+		}
+	}
+	return items, nil
+}
+
+// fetchDependencies walks down the dependencies of the tasks one level at a
+// time, querying every task of a level not already in the graph in batches.
+// Tasks already in the graph are walked as well, a parent found walking up
+// was queried without its dependencies.
+func (pc *phabCommand) fetchDependencies(tasks []*entities.ManiphestTask) error {
+	for level := tasks; len(level) > 0; {
+		var walk []*entities.ManiphestTask
+		for _, task := range level {
+			if !pc.graph.walkedDown[task.PHID] {
+				pc.graph.walkedDown[task.PHID] = true
+				walk = append(walk, task)
+			}
+		}
+
+		if phids := pc.graph.missing(walk); len(phids) > 0 {
+			pc.logger.Debug("fetching dependant tasks", zap.Int("count", len(phids)))
+			deps, err := pc.phabManiphestQueryBatch(phids)
 			if err != nil {
-				return nil, err
+				return err
 			}
+			pc.graph.queried(phids)
+			for _, dep := range deps {
+				pc.graph.add(dep)
+			}
+		}
 
-			child.Items = newTasks
+		level = nil
+		for _, task := range walk {
+			level = append(level, pc.graph.dependencies(task)...)
 		}
-		items = append(items, child)
 	}
-	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
-	return items, nil
+	return nil
+}
+
+// fetchParents walks up the tasks depending on the tasks one level at a time
+// until reaching tasks nothing depends on.
+func (pc *phabCommand) fetchParents(tasks []*entities.ManiphestTask) error {
+	for level := tasks; len(level) > 0; {
+		var ids []int
+		for _, task := range level {
+			if pc.graph.walkedUp[task.PHID] {
+				continue
+			}
+			pc.graph.walkedUp[task.PHID] = true
+			id, err := strconv.Atoi(task.ID)
+			if err != nil {
+				return errors.Wrapf(err, "invalid task id for %s", task.ObjectName)
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		phids, err := pc.phabManiphestSearchParents(ids)
+		if err != nil {
+			return err
+		}
+		var missing []string
+		for _, phid := range phids {
+			if _, ok := pc.graph.tasks[phid]; !ok {
+				missing = append(missing, phid)
+			}
+		}
+		if len(missing) > 0 {
+			pc.logger.Debug("fetching parent tasks", zap.Int("count", len(missing)))
+			parents, err := pc.phabManiphestQueryBatch(missing)
+			if err != nil {
+				return err
+			}
+			pc.graph.queried(missing)
+			for _, parent := range parents {
+				pc.graph.add(parent)
+			}
+		}
+
+		level = pc.graph.lookup(phids)
+		pc.graph.linkParents(level)
+	}
+	return nil
+}
+
+// phabManiphestSearchParents returns the PHIDs of every task that has one of
+// the tasks as a subtask.
+func (pc *phabCommand) phabManiphestSearchParents(ids []int) ([]string, error) {
+	var phids []string
+	req := phab.ManifestSearch{Constraints: phab.SearchConstaints{SubtaskIDs: ids}}
+	for {
+		var res phab.ManifestSearchResponse
+		if err := pc.client.Call("maniphest.search", &req, &res); err != nil {
+			return nil, err
+		}
+		for _, task := range res.Data {
+			phids = append(phids, task.PHID)
+		}
+		if res.Cursor.After == "" || len(res.Data) == 0 {
+			return phids, nil
+		}
+		req.After = res.Cursor.After.String()
+	}
+}
+
+// phabManiphestQueryBatch queries the tasks in chunks of BatchSize, running
+// up to Concurrency queries at the same time.
+func (pc *phabCommand) phabManiphestQueryBatch(phids []string) ([]*entities.ManiphestTask, error) {
+	batchSize := pc.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+	concurrency := pc.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	chunks := chunkStrings(phids, batchSize)
+	results := make([][]*entities.ManiphestTask, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res, err := pc.client.ManiphestQuery(requests.ManiphestQueryRequest{
+				PHIDs:  chunk,
+				Status: pc.queryStatus(),
+				Limit:  uint64(len(chunk)),
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for _, task := range *res {
+				results[i] = append(results[i], task)
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	if err := multierr.Combine(errs...); err != nil {
+		return nil, err
+	}
+	var tasks []*entities.ManiphestTask
+	for _, result := range results {
+		tasks = append(tasks, result...)
+	}
+	sortTasks(tasks)
+	return tasks, nil
 }
 
 func phabProjectLookup(client *gonduit.Conn, projects []string) (map[string]*entities.Project, error) {
@@ -206,12 +567,28 @@ func phabProjectLookup(client *gonduit.Conn, projects []string) (map[string]*ent
 	}
 
 	for _, project := range res.Data {
+		project := project
 		projectMap[project.Name] = &project
 	}
 
 	return projectMap, nil
 }
 
+// phabProjectNamesByPHID returns the names of the projects keyed by PHID.
+func phabProjectNamesByPHID(client *gonduit.Conn, phids []string) (map[string]string, error) {
+	names := make(map[string]string, len(phids))
+	for _, chunk := range chunkStrings(phids, defaultBatchSize) {
+		res, err := client.ProjectQuery(requests.ProjectQueryRequest{PHIDs: chunk})
+		if err != nil {
+			return nil, err
+		}
+		for phid, project := range res.Data {
+			names[phid] = project.Name
+		}
+	}
+	return names, nil
+}
+
 func compareProjects(projects []string, foundProjects map[string]*entities.Project) error {
 	// Check that we found all the projects we were looking for.
 	var errs []error
@@ -222,3 +599,15 @@ func compareProjects(projects []string, foundProjects map[string]*entities.Proje
 	}
 	return multierr.Combine(errs...)
 }
+
+// chunkStrings splits the list into chunks of at most size items.
+func chunkStrings(list []string, size int) [][]string {
+	var chunks [][]string
+	for size < len(list) {
+		list, chunks = list[size:], append(chunks, list[:size])
+	}
+	if len(list) > 0 {
+		chunks = append(chunks, list)
+	}
+	return chunks
+}
@@ -2,16 +2,24 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
 
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/core"
 	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/requests"
 	"github.com/etcinit/gonduit/responses"
 	"github.com/etcinit/gonduit/test/server"
+	"github.com/etcinit/gonduit/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -254,6 +262,707 @@ func TestPhabCommandProjects(t *testing.T) {
 	}
 }
 
+func TestPhabCommandOwners(t *testing.T) {
+	tests := []struct {
+		users    phab.UserQueryResponse
+		tasks    responses.ManiphestQueryResponse
+		owners   string
+		wantOut  string
+		wantErr  string
+		wantLogs []observer.LoggedEntry
+	}{
+		{
+			wantOut: "Owner: alice\nT1: Fix the thing\n",
+			owners:  "alice",
+			users:   phab.UserQueryResponse{{UserName: "alice", PHID: "PHID-USER-alice"}},
+			tasks: responses.ManiphestQueryResponse{
+				"PHID-TASK-1": &entities.ManiphestTask{ID: "1", ObjectName: "T1", Title: "Fix the thing", OwnerPHID: "PHID-USER-alice"},
+			},
+		},
+		{
+			wantOut: "Owner: alice\nOwner: bob\n",
+			owners:  "alice,bob",
+			users: phab.UserQueryResponse{
+				{UserName: "bob", PHID: "PHID-USER-bob"},
+				{UserName: "alice", PHID: "PHID-USER-alice"},
+			},
+		},
+		{
+			wantOut: "Owner: alice\n",
+			owners:  "alice,carol",
+			users:   phab.UserQueryResponse{{UserName: "alice", PHID: "PHID-USER-alice"}},
+			wantLogs: []observer.LoggedEntry{{
+				Entry:   zapcore.Entry{Level: zap.ErrorLevel, Message: "errors looking up owners"},
+				Context: []zapcore.Field{zap.Error(errors.New("user not found in phab: carol"))},
+			}},
+		},
+	}
+	logcore, obsLogs := observer.New(zap.InfoLevel)
+	logger := zap.New(logcore)
+	outputBuf := &bytes.Buffer{}
+
+	for _, tt := range tests {
+		t.Run(tt.owners, func(t *testing.T) {
+			outputBuf.Reset()
+
+			s := server.New()
+			defer s.Close()
+
+			s.RegisterCapabilities()
+			s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{"result": tt.users})
+			s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{"result": tt.tasks})
+
+			baseCmd := newPhabListCommand(&options{}, logger)
+			cmd, ok := baseCmd.(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+
+			cmd.output = outputBuf
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.TasksByOwner = tt.owners
+
+			err := cmd.Execute(nil /* args */)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantOut, outputBuf.String())
+			if len(tt.wantLogs) > 0 {
+				assert.Equal(t, tt.wantLogs, obsLogs.AllUntimed())
+			}
+			obsLogs.TakeAll()
+		})
+	}
+}
+
+func TestPhabCommandOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		graph   string
+		wantOut string
+	}{
+		{
+			output:  "text",
+			wantOut: "T1: Fix the thing\nTask: T1 - status: open\n",
+		},
+		{
+			output: "json",
+			wantOut: `{
+  "trees": [
+    {
+      "id": "1",
+      "phid": "PHID-TASK-1",
+      "name": "T1",
+      "title": "Fix the thing",
+      "status": "open",
+      "priority": "High"
+    }
+  ],
+  "tasks": [
+    {
+      "name": "T1",
+      "phid": "PHID-TASK-1",
+      "type": "TASK",
+      "status": "open"
+    }
+  ]
+}
+`,
+		},
+		{
+			output: "yaml",
+			wantOut: `trees:
+- id: "1"
+  phid: PHID-TASK-1
+  name: T1
+  title: Fix the thing
+  status: open
+  priority: High
+tasks:
+- name: T1
+  phid: PHID-TASK-1
+  type: TASK
+  status: open
+`,
+		},
+		{
+			output: "csv",
+			wantOut: "kind,name,title,status,priority,parent,depth,project,owner\n" +
+				"tree,T1,Fix the thing,open,High,,0,,\n" +
+				"task,T1,,open,,,,,\n",
+		},
+		{
+			graph: "dot",
+			wantOut: `digraph tasks {
+  rankdir=LR;
+  node [shape=box, style=filled, fontcolor=white];
+  "T1" [label="T1: Fix the thing", fillcolor="#c0392b", style="filled"];
+}
+`,
+		},
+		{
+			graph: "mermaid",
+			wantOut: `flowchart LR
+  T1["T1: Fix the thing"]
+  style T1 fill:#c0392b,color:#fff
+`,
+		},
+	}
+	logger := zap.NewNop()
+	outputBuf := &bytes.Buffer{}
+
+	for _, tt := range tests {
+		t.Run(tt.output+tt.graph, func(t *testing.T) {
+			outputBuf.Reset()
+
+			s := server.New()
+			defer s.Close()
+
+			s.RegisterCapabilities()
+			s.RegisterMethod("phid.lookup", http.StatusOK, map[string]interface{}{
+				"result": responses.PHIDLookupResponse{
+					"T1": &entities.PHIDResult{Name: "T1", PHID: "PHID-TASK-1", Status: "open", Type: "TASK"},
+				},
+			})
+			s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{
+				"result": responses.ManiphestQueryResponse{
+					"PHID-TASK-1": &entities.ManiphestTask{
+						ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "Fix the thing", Status: "open", Priority: "High",
+					},
+				},
+			})
+
+			baseCmd := newPhabListCommand(&options{}, logger)
+			cmd, ok := baseCmd.(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+
+			cmd.output = outputBuf
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.Tasks = "T1"
+			cmd.Output = tt.output
+			cmd.Graph = tt.graph
+
+			require.NoError(t, cmd.Execute(nil /* args */))
+			assert.Equal(t, tt.wantOut, outputBuf.String())
+		})
+	}
+}
+
+func TestPhabCommandOutputOwners(t *testing.T) {
+	tests := []struct {
+		output  string
+		wantOut string
+	}{
+		{
+			output: "json",
+			wantOut: `{
+  "owners": [
+    {
+      "name": "alice",
+      "trees": [
+        {
+          "id": "1",
+          "phid": "PHID-TASK-1",
+          "name": "T1",
+          "title": "one",
+          "status": "open",
+          "priority": "Low",
+          "ownerPHID": "PHID-USER-alice"
+        }
+      ]
+    },
+    {
+      "name": "bob",
+      "trees": [
+        {
+          "id": "2",
+          "phid": "PHID-TASK-2",
+          "name": "T2",
+          "title": "two",
+          "status": "open",
+          "priority": "Low",
+          "ownerPHID": "PHID-USER-bob"
+        }
+      ]
+    }
+  ]
+}
+`,
+		},
+		{
+			output: "csv",
+			wantOut: "kind,name,title,status,priority,parent,depth,project,owner\n" +
+				"owner,alice,,,,,,,alice\n" +
+				"tree,T1,one,open,Low,,0,,alice\n" +
+				"owner,bob,,,,,,,bob\n" +
+				"tree,T2,two,open,Low,,0,,bob\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			s := server.New()
+			defer s.Close()
+			s.RegisterCapabilities()
+			s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{
+				"result": phab.UserQueryResponse{
+					{UserName: "alice", PHID: "PHID-USER-alice"},
+					{UserName: "bob", PHID: "PHID-USER-bob"},
+				},
+			})
+
+			tasks := map[string]responses.ManiphestQueryResponse{
+				"PHID-USER-alice": {
+					"PHID-TASK-1": {ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "one", Status: "open", Priority: "Low", OwnerPHID: "PHID-USER-alice"},
+				},
+				"PHID-USER-bob": {
+					"PHID-TASK-2": {ID: "2", PHID: "PHID-TASK-2", ObjectName: "T2", Title: "two", Status: "open", Priority: "Low", OwnerPHID: "PHID-USER-bob"},
+				},
+			}
+			rs := newRecordingServer(t, s)
+			defer rs.Close()
+			rs.answer = func(method string, params json.RawMessage) (map[string]interface{}, bool) {
+				var req requests.ManiphestQueryRequest
+				if method != "maniphest.query" || json.Unmarshal(params, &req) != nil || len(req.OwnerPHIDs) != 1 {
+					return nil, false
+				}
+				return map[string]interface{}{"result": tasks[req.OwnerPHIDs[0]]}, true
+			}
+
+			cmd, ok := newPhabListCommand(&options{}, zap.NewNop()).(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.PhabURI = rs.URL
+			cmd.PhabAPIToken = "some-token"
+			cmd.TasksByOwner = "alice,bob"
+			cmd.Output = tt.output
+
+			require.NoError(t, cmd.Execute(nil /* args */))
+			assert.Equal(t, tt.wantOut, out.String())
+		})
+	}
+}
+
+func TestPhabCommandTaskCycle(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+
+	s.RegisterCapabilities()
+	s.RegisterMethod("phid.lookup", http.StatusOK, map[string]interface{}{
+		"result": responses.PHIDLookupResponse{
+			"T1": &entities.PHIDResult{Name: "T1", PHID: "PHID-TASK-1", Status: "open", Type: "TASK"},
+		},
+	})
+	// every query returns the same task that depends on itself
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ManiphestQueryResponse{
+			"PHID-TASK-1": &entities.ManiphestTask{
+				ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "Loop", DependsOnTaskPHIDs: []string{"PHID-TASK-1"},
+			},
+		},
+	})
+
+	baseCmd := newPhabListCommand(&options{}, zap.NewNop())
+	cmd, ok := baseCmd.(*phabCommand)
+	require.True(t, ok, "conversion to phabCommand failed")
+
+	outputBuf := &bytes.Buffer{}
+	cmd.output = outputBuf
+	cmd.PhabURI = s.GetURL()
+	cmd.PhabAPIToken = "some-token"
+	cmd.Tasks = "T1"
+
+	require.NoError(t, cmd.Execute(nil /* args */))
+	assert.Equal(t, "T1: Loop\n└── ↻ T1 (cycle)\nTask: T1 - status: open\n", outputBuf.String())
+}
+
+func TestPhabCommandTaskDirection(t *testing.T) {
+	tests := []struct {
+		direction string
+		wantOut   string
+	}{
+		{
+			direction: "down",
+			wantOut:   "T2: two\n",
+		},
+		{
+			direction: "up",
+			wantOut:   "T2: two (blocks)\n└── T1:        - one\n    └── T0:        - zero\n",
+		},
+		{
+			direction: "both",
+			wantOut:   "T2: two\nT2: two (blocks)\n└── T1:        - one\n    └── T0:        - zero\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.direction, func(t *testing.T) {
+			s := server.New()
+			defer s.Close()
+
+			s.RegisterCapabilities()
+			// the fake server always answers the same, return every parent of the chain T0 -> T1 -> T2
+			s.RegisterMethod("maniphest.search", http.StatusOK, map[string]interface{}{
+				"result": map[string]interface{}{
+					"data":   []phab.ManifestSearchResult{{ID: 1, PHID: "P1"}, {ID: 0, PHID: "P0"}},
+					"cursor": map[string]interface{}{"limit": 100, "after": nil},
+				},
+			})
+
+			baseCmd := newPhabListCommand(&options{}, zap.NewNop())
+			cmd, ok := baseCmd.(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+
+			client, err := gonduit.Dial(s.GetURL(), &core.ClientOptions{APIToken: "some-token"})
+			require.NoError(t, err)
+			cmd.client = client
+
+			// all tasks are already cached so maniphest.query is never called
+			cmd.graph = newTaskGraph()
+			for _, task := range []*entities.ManiphestTask{
+				{ID: "0", PHID: "P0", ObjectName: "T0", Title: "zero", DependsOnTaskPHIDs: []string{"P1"}},
+				{ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", DependsOnTaskPHIDs: []string{"P2"}},
+				{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two"},
+			} {
+				cmd.graph.add(task)
+			}
+
+			trees, err := cmd.taskTrees([]*entities.ManiphestTask{cmd.graph.tasks["P2"]}, tt.direction)
+			require.NoError(t, err)
+
+			var out string
+			for _, tree := range trees {
+				out += phab.StringTree(tree)
+			}
+			assert.Equal(t, tt.wantOut, out)
+		})
+	}
+}
+
+func TestPhabCommandWalkKnownTasks(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+	s.RegisterCapabilities()
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ManiphestQueryResponse{
+			"P3": &entities.ManiphestTask{ID: "3", PHID: "P3", ObjectName: "T3", Title: "three"},
+		},
+	})
+
+	baseCmd := newPhabListCommand(&options{}, zap.NewNop())
+	cmd, ok := baseCmd.(*phabCommand)
+	require.True(t, ok, "conversion to phabCommand failed")
+	client, err := gonduit.Dial(s.GetURL(), &core.ClientOptions{APIToken: "some-token"})
+	require.NoError(t, err)
+	cmd.client = client
+
+	// T1 was found walking up from T2 so its dependency T3 was never fetched
+	cmd.graph = newTaskGraph()
+	for _, task := range []*entities.ManiphestTask{
+		{ID: "9", PHID: "P9", ObjectName: "T9", Title: "nine", DependsOnTaskPHIDs: []string{"P1"}},
+		{ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", DependsOnTaskPHIDs: []string{"P2", "P3"}},
+		{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two"},
+	} {
+		cmd.graph.add(task)
+	}
+	cmd.graph.walkedDown["P2"] = true
+
+	trees, err := cmd.taskTrees([]*entities.ManiphestTask{cmd.graph.tasks["P9"]}, directionDown)
+	require.NoError(t, err)
+	require.Len(t, trees, 1)
+	assert.Equal(t, "T9: nine\n└── T1:        - one\n    ├── T2:        - two\n    └── T3:        - three\n", phab.StringTree(trees[0]))
+
+	// the next section shows the shared T1 in full again
+	trees, err = cmd.taskTrees([]*entities.ManiphestTask{cmd.graph.tasks["P1"]}, directionDown)
+	require.NoError(t, err)
+	assert.True(t, trees[0].Shared, "T1 was already written out in this section")
+	cmd.graph.newSection()
+	trees, err = cmd.taskTrees([]*entities.ManiphestTask{cmd.graph.tasks["P1"]}, directionDown)
+	require.NoError(t, err)
+	assert.False(t, trees[0].Shared)
+	assert.Len(t, trees[0].Items, 2)
+}
+
+func TestPhabCommandStatus(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+
+	s.RegisterCapabilities()
+	s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{
+		"result": phab.UserQueryResponse{{UserName: "alice", PHID: "PHID-USER-alice"}},
+	})
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ManiphestQueryResponse{
+			"P1": &entities.ManiphestTask{ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", Status: "resolved", DependsOnTaskPHIDs: []string{"P2"}},
+			"P2": &entities.ManiphestTask{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two", Status: "open", Priority: "Low"},
+		},
+	})
+
+	baseCmd := newPhabListCommand(&options{}, zap.NewNop())
+	cmd, ok := baseCmd.(*phabCommand)
+	require.True(t, ok, "conversion to phabCommand failed")
+
+	outputBuf := &bytes.Buffer{}
+	cmd.output = outputBuf
+	cmd.PhabURI = s.GetURL()
+	cmd.PhabAPIToken = "some-token"
+	cmd.TasksByOwner = "alice"
+	cmd.Status = "any"
+
+	require.NoError(t, cmd.Execute(nil /* args */))
+	assert.Equal(t, "status-any", cmd.queryStatus())
+	assert.Equal(t, "Owner: alice\nT1: one [resolved]\n└── T2: LOW    - two\nT2: two\n", outputBuf.String())
+}
+
+func TestPhabCommandColumns(t *testing.T) {
+	modified := util.UnixTimestamp(time.Date(2018, 6, 1, 12, 0, 0, 0, time.Local))
+	s := server.New()
+	defer s.Close()
+
+	s.RegisterCapabilities()
+	s.RegisterMethod("user.query", http.StatusOK, map[string]interface{}{
+		"result": phab.UserQueryResponse{
+			{UserName: "alice", PHID: "PHID-USER-alice"},
+			{UserName: "bob", PHID: "PHID-USER-bob"},
+		},
+	})
+	s.RegisterMethod("project.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ProjectQueryResponse{
+			Data: map[string]entities.Project{
+				"PHID-PROJ-ops": {Name: "ops team", PHID: "PHID-PROJ-ops"},
+			},
+		},
+	})
+	s.RegisterMethod("maniphest.query", http.StatusOK, map[string]interface{}{
+		"result": responses.ManiphestQueryResponse{
+			"P1": &entities.ManiphestTask{
+				ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", Status: "open", DependsOnTaskPHIDs: []string{"P2"},
+				OwnerPHID: "PHID-USER-alice", CCPHIDs: []string{"PHID-USER-bob"}, ProjectPHIDs: []string{"PHID-PROJ-ops"},
+				DateModified: modified,
+			},
+			"P2": &entities.ManiphestTask{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two", Status: "open", Priority: "Low"},
+		},
+	})
+
+	tests := []struct {
+		columns string
+		want    string
+		wantErr string
+	}{
+		{
+			columns: "owner,ccs,projects,modified",
+			want: "Owner: alice\n" +
+				"T1: one | @alice | @bob | #ops_team | 2018-06-01\n" +
+				"└── T2: LOW    - two | - | - | - | -\n" +
+				"T2: two | - | - | - | -\n",
+		},
+		{
+			columns: "status",
+			want:    "Owner: alice\nT1: one | open\n└── T2: LOW    - two | open\nT2: two | open\n",
+		},
+		{columns: "owner,size", wantErr: `unknown column "size", the columns are owner, ccs, status, projects, modified`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.columns, func(t *testing.T) {
+			cmd, ok := newPhabListCommand(&options{}, zap.NewNop()).(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+
+			outputBuf := &bytes.Buffer{}
+			cmd.output = outputBuf
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.TasksByOwner = "alice"
+			cmd.Columns = tt.columns
+
+			err := cmd.Execute(nil /* args */)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, outputBuf.String())
+		})
+	}
+}
+
+func TestTaskGraphExport(t *testing.T) {
+	g := newTaskGraph()
+	for _, task := range []*entities.ManiphestTask{
+		{ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", Priority: "Normal", DependsOnTaskPHIDs: []string{"P2", "P3"}},
+		{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two", Priority: "Low", DependsOnTaskPHIDs: []string{"P3"}},
+		{ID: "3", PHID: "P3", ObjectName: "T3", Title: `say "hi"`, Status: "resolved", DependsOnTaskPHIDs: []string{"P1"}},
+	} {
+		g.add(task)
+	}
+	trees := []*phab.TaskTree{g.tree(g.tasks["P1"])}
+
+	assert.Equal(t, `digraph tasks {
+  rankdir=LR;
+  node [shape=box, style=filled, fontcolor=white];
+  "T1" [label="T1: one", fillcolor="#e67e22", style="filled"];
+  "T2" [label="T2: two", fillcolor="#f1c40f", style="filled"];
+  "T3" [label="T3: say \"hi\" [resolved]", fillcolor="#95a5a6", style="filled,dashed"];
+  "T1" -> "T2";
+  "T2" -> "T3";
+  "T3" -> "T1";
+  "T1" -> "T3";
+}
+`, phab.DOT(trees))
+
+	assert.Equal(t, `flowchart LR
+  T1["T1: one"]
+  T2["T2: two"]
+  T3["T3: say #quot;hi#quot; [resolved]"]
+  T1 --> T2
+  T2 --> T3
+  T3 --> T1
+  T1 --> T3
+  style T1 fill:#e67e22,color:#fff
+  style T2 fill:#f1c40f,color:#fff
+  style T3 fill:#95a5a6,color:#fff,stroke-dasharray:5 5
+`, phab.Mermaid(trees))
+}
+
+func TestTaskGraphSharedSubtree(t *testing.T) {
+	// T1 depends on T2 and T3 which both depend on T4 which depends on T5
+	g := newTaskGraph()
+	for _, task := range []*entities.ManiphestTask{
+		{ID: "1", PHID: "P1", ObjectName: "T1", Title: "one", DependsOnTaskPHIDs: []string{"P2", "P3"}},
+		{ID: "2", PHID: "P2", ObjectName: "T2", Title: "two", DependsOnTaskPHIDs: []string{"P4"}},
+		{ID: "3", PHID: "P3", ObjectName: "T3", Title: "three", DependsOnTaskPHIDs: []string{"P4", "P6"}},
+		{ID: "4", PHID: "P4", ObjectName: "T4", Title: "four", DependsOnTaskPHIDs: []string{"P5"}},
+		{ID: "5", PHID: "P5", ObjectName: "T5", Title: "five"},
+	} {
+		g.add(task)
+	}
+	g.queried([]string{"P6"})
+	assert.Empty(t, g.missing([]*entities.ManiphestTask{g.tasks["P1"], g.tasks["P3"]}))
+
+	want := "T1: one\n" +
+		"├── T2:        - two\n" +
+		"│   └── T4:        - four\n" +
+		"│       └── T5:        - five\n" +
+		"└── T3:        - three\n" +
+		"    └── ↑ T4 (see above)\n"
+	assert.Equal(t, want, phab.StringTree(g.tree(g.tasks["P1"])))
+	assert.Equal(t, "T2: two (see above)\n", phab.StringTree(g.tree(g.tasks["P2"])))
+}
+
+func TestPhabCommandFetchDependencies(t *testing.T) {
+	// T1 depends on T2 and T3, which depend on T4 and T5
+	all := map[string]*entities.ManiphestTask{
+		"PHID-TASK-1": {ID: "1", PHID: "PHID-TASK-1", ObjectName: "T1", Title: "one", DependsOnTaskPHIDs: []string{"PHID-TASK-2", "PHID-TASK-3"}},
+		"PHID-TASK-2": {ID: "2", PHID: "PHID-TASK-2", ObjectName: "T2", Title: "two", DependsOnTaskPHIDs: []string{"PHID-TASK-4"}},
+		"PHID-TASK-3": {ID: "3", PHID: "PHID-TASK-3", ObjectName: "T3", Title: "three", DependsOnTaskPHIDs: []string{"PHID-TASK-5"}},
+		"PHID-TASK-4": {ID: "4", PHID: "PHID-TASK-4", ObjectName: "T4", Title: "four"},
+		"PHID-TASK-5": {ID: "5", PHID: "PHID-TASK-5", ObjectName: "T5", Title: "five"},
+	}
+
+	tests := []struct {
+		msg     string
+		failing []string
+		wantOut string
+		wantErr []string
+	}{
+		{
+			msg: "every level in batches of one",
+			wantOut: "T1: one\n" +
+				"├── T2:        - two\n" +
+				"│   └── T4:        - four\n" +
+				"└── T3:        - three\n" +
+				"    └── T5:        - five\n" +
+				"Task: T1 - status: open\n",
+		},
+		{
+			msg:     "every failed batch is reported",
+			failing: []string{"PHID-TASK-4", "PHID-TASK-5"},
+			wantErr: []string{"no task PHID-TASK-4", "no task PHID-TASK-5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := server.New()
+			defer s.Close()
+			s.RegisterCapabilities()
+			s.RegisterMethod("phid.lookup", http.StatusOK, map[string]interface{}{
+				"result": responses.PHIDLookupResponse{
+					"T1": &entities.PHIDResult{Name: "T1", PHID: "PHID-TASK-1", Status: "open", Type: "TASK"},
+				},
+			})
+
+			rs := newRecordingServer(t, s)
+			defer rs.Close()
+			rs.answer = func(method string, params json.RawMessage) (map[string]interface{}, bool) {
+				var req requests.ManiphestQueryRequest
+				if method != "maniphest.query" || json.Unmarshal(params, &req) != nil {
+					return nil, false
+				}
+				res := responses.ManiphestQueryResponse{}
+				for _, phid := range req.PHIDs {
+					for _, failing := range tt.failing {
+						if phid == failing {
+							return map[string]interface{}{"error_code": "ERR-CONDUIT-CORE", "error_info": "no task " + phid}, true
+						}
+					}
+					res[phid] = all[phid]
+				}
+				return map[string]interface{}{"result": res}, true
+			}
+
+			cmd, ok := newPhabListCommand(&options{}, zap.NewNop()).(*phabCommand)
+			require.True(t, ok, "conversion to phabCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.PhabURI = rs.URL
+			cmd.PhabAPIToken = "some-token"
+			cmd.Tasks = "T1"
+			cmd.BatchSize = 1
+			cmd.Concurrency = 2
+
+			err := cmd.Execute(nil /* args */)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				for _, want := range tt.wantErr {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOut, out.String())
+
+			// the root, then a query per task of each of the two levels below it
+			queries := rs.params("maniphest.query")
+			require.Len(t, queries, 5)
+			for _, params := range queries {
+				var req requests.ManiphestQueryRequest
+				require.NoError(t, json.Unmarshal(params, &req))
+				assert.Len(t, req.PHIDs, 1, "batches hold a single task")
+			}
+		})
+	}
+}
+
+func TestChunkStrings(t *testing.T) {
+	tests := []struct {
+		list []string
+		size int
+		want [][]string
+	}{
+		{list: nil, size: 2, want: nil},
+		{list: []string{"a"}, size: 2, want: [][]string{{"a"}}},
+		{list: []string{"a", "b"}, size: 2, want: [][]string{{"a", "b"}}},
+		{list: []string{"a", "b", "c", "d", "e"}, size: 2, want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, chunkStrings(tt.list, tt.size))
+	}
+}
+
 func TestPhabCommandArgs(t *testing.T) {
 	tests := []struct {
 		phabURI           string
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	graphDOT     = "dot"
+	graphMermaid = "mermaid"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+	outputCSV  = "csv"
+)
+
+// listRenderer receives the results of the phab command as they are found.
+type listRenderer interface {
+	Project(name string)
+	Owner(name string)
+	// Selected starts the trees of the tasks picked by name, they belong to no project or owner
+	Selected()
+	Tree(t *phab.TaskTree)
+	Task(t *entities.PHIDResult)
+	// Flush writes anything buffered to the output
+	Flush() error
+}
+
+func newListRenderer(format, graph string, columns []string, w io.Writer) (listRenderer, error) {
+	if graph != "" {
+		if format != "" && format != outputText {
+			return nil, errors.New("--graph can not be combined with --output")
+		}
+		return newGraphRenderer(graph, w)
+	}
+	switch format {
+	case "", outputText:
+		return &textRenderer{w: w, columns: columns}, nil
+	case outputJSON, outputYAML, outputCSV:
+		return &structuredRenderer{format: format, w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s", format)
+}
+
+// textRenderer writes the human readable tree as soon as results come in.
+type textRenderer struct {
+	w io.Writer
+	// columns are shown after every task of the trees
+	columns []string
+}
+
+func (r *textRenderer) Project(name string) {
+	fmt.Fprintf(r.w, "Project: %s\n", name)
+}
+
+func (r *textRenderer) Owner(name string) {
+	fmt.Fprintf(r.w, "Owner: %s\n", name)
+}
+
+func (r *textRenderer) Selected() {}
+
+func (r *textRenderer) Tree(t *phab.TaskTree) {
+	fmt.Fprint(r.w, phab.StringTreeColumns(t, r.columns))
+}
+
+func (r *textRenderer) Task(t *entities.PHIDResult) {
+	fmt.Fprintf(r.w, "Task: %s - status: %s\n", t.Name, t.Status)
+}
+
+func (r *textRenderer) Flush() error {
+	return nil
+}
+
+type listTask struct {
+	Name   string `json:"name" yaml:"name"`
+	PHID   string `json:"phid" yaml:"phid"`
+	Type   string `json:"type" yaml:"type"`
+	Status string `json:"status" yaml:"status"`
+	URI    string `json:"uri,omitempty" yaml:"uri,omitempty"`
+}
+
+// listResult is the document written by the structured renderers. Trees are
+// nested under the project or owner they were listed for like the text output.
+type listResult struct {
+	Projects *listProjects    `json:"projects,omitempty" yaml:"projects,omitempty"`
+	Owners   []*listOwner     `json:"owners,omitempty" yaml:"owners,omitempty"`
+	Trees    []*phab.TaskNode `json:"trees,omitempty" yaml:"trees,omitempty"`
+	Tasks    []listTask       `json:"tasks,omitempty" yaml:"tasks,omitempty"`
+}
+
+// listProjects holds the trees of the tasks tagged with all of the projects.
+type listProjects struct {
+	Names []string         `json:"names" yaml:"names"`
+	Trees []*phab.TaskNode `json:"trees" yaml:"trees"`
+}
+
+// listOwner holds the trees of the tasks of an owner.
+type listOwner struct {
+	Name  string           `json:"name" yaml:"name"`
+	Trees []*phab.TaskNode `json:"trees" yaml:"trees"`
+}
+
+// structuredRenderer collects all results and encodes them once on Flush.
+type structuredRenderer struct {
+	format string
+	w      io.Writer
+	result listResult
+	// trees is where the next tree goes, the trees of the last project or owner
+	trees *[]*phab.TaskNode
+}
+
+func (r *structuredRenderer) Project(name string) {
+	if r.result.Projects == nil {
+		r.result.Projects = &listProjects{}
+	}
+	r.result.Projects.Names = append(r.result.Projects.Names, name)
+	r.trees = &r.result.Projects.Trees
+}
+
+func (r *structuredRenderer) Owner(name string) {
+	owner := &listOwner{Name: name}
+	r.result.Owners = append(r.result.Owners, owner)
+	r.trees = &owner.Trees
+}
+
+func (r *structuredRenderer) Selected() {
+	r.trees = &r.result.Trees
+}
+
+func (r *structuredRenderer) Tree(t *phab.TaskTree) {
+	if r.trees == nil {
+		r.trees = &r.result.Trees
+	}
+	*r.trees = append(*r.trees, phab.NewTaskNode(t))
+}
+
+func (r *structuredRenderer) Task(t *entities.PHIDResult) {
+	r.result.Tasks = append(r.result.Tasks, listTask{
+		Name:   t.Name,
+		PHID:   t.PHID,
+		Type:   t.Type,
+		Status: t.Status,
+		URI:    t.URI,
+	})
+}
+
+func (r *structuredRenderer) Flush() error {
+	if r.format == outputCSV {
+		return r.writeCSV()
+	}
+	return encodeDocument(r.format, r.w, r.result)
+}
+
+// encodeDocument writes v as an indented json or a yaml document.
+func encodeDocument(format string, w io.Writer, v interface{}) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal yaml output")
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// writeCSV flattens everything into rows, the kind column tells them apart and
+// the project and owner columns what a tree was listed for.
+func (r *structuredRenderer) writeCSV() error {
+	w := csv.NewWriter(r.w)
+	w.Write([]string{"kind", "name", "title", "status", "priority", "parent", "depth", "project", "owner"})
+	writeTrees := func(trees []*phab.TaskNode, project, owner string) {
+		for _, tree := range trees {
+			for _, t := range phab.Flatten(tree) {
+				w.Write([]string{"tree", t.Name, t.Title, t.Status, t.Priority, t.Parent, strconv.Itoa(t.Depth), project, owner})
+			}
+		}
+	}
+	if projects := r.result.Projects; projects != nil {
+		for _, p := range projects.Names {
+			w.Write([]string{"project", p, "", "", "", "", "", p, ""})
+		}
+		// the trees match every project, the names are joined like a roster list
+		writeTrees(projects.Trees, strings.Join(projects.Names, ";"), "")
+	}
+	for _, o := range r.result.Owners {
+		w.Write([]string{"owner", o.Name, "", "", "", "", "", "", o.Name})
+		writeTrees(o.Trees, "", o.Name)
+	}
+	writeTrees(r.result.Trees, "", "")
+	for _, t := range r.result.Tasks {
+		w.Write([]string{"task", t.Name, "", t.Status, "", "", "", "", ""})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// graphRenderer collects the trees and writes them as a single graph on Flush,
+// projects and the task list are left out.
+type graphRenderer struct {
+	encode func([]*phab.TaskTree) string
+	w      io.Writer
+	trees  []*phab.TaskTree
+}
+
+func newGraphRenderer(graph string, w io.Writer) (*graphRenderer, error) {
+	switch graph {
+	case graphDOT:
+		return &graphRenderer{encode: phab.DOT, w: w}, nil
+	case graphMermaid:
+		return &graphRenderer{encode: phab.Mermaid, w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown graph format: %s", graph)
+}
+
+func (r *graphRenderer) Project(string) {}
+
+func (r *graphRenderer) Owner(string) {}
+
+func (r *graphRenderer) Selected() {}
+
+func (r *graphRenderer) Tree(t *phab.TaskTree) {
+	r.trees = append(r.trees, t)
+}
+
+func (r *graphRenderer) Task(*entities.PHIDResult) {}
+
+func (r *graphRenderer) Flush() error {
+	_, err := io.WriteString(r.w, r.encode(r.trees))
+	return err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/entities"
+	"go.uber.org/zap"
+)
+
+const (
+	noOwner   = "(no owner)"
+	noProject = "(no project)"
+)
+
+type phabStaleCommand struct {
+	baseCommand
+
+	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
+	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
+
+	taskSelection
+
+	IdleDays   int `long:"idle-days" description:"Only report tasks not modified for at least this many days" default:"30"`
+	MinAgeDays int `long:"min-age-days" description:"Only report tasks created at least this many days ago" default:"0"`
+
+	Output string `long:"output" short:"o" description:"The format to write results in" default:"text" choice:"text" choice:"json" choice:"yaml" choice:"csv"`
+
+	output io.Writer
+	// The phab conduit client for the command to share the client session
+	client *gonduit.Conn
+	// now is the time the idle days are counted from
+	now func() time.Time
+}
+
+func newPhabStaleCommand(opts *options, logger *zap.Logger) command {
+	return &phabStaleCommand{
+		baseCommand: newBaseCommand(
+			"phab-stale",
+			"Report the tasks that have not moved in a while.",
+			"List the tasks idle for longer than a number of days grouped by owner and then project, oldest first.",
+			opts, logger),
+		output: os.Stdout,
+		now:    time.Now,
+	}
+}
+
+// staleTask is a single task of the report.
+type staleTask struct {
+	Name     string    `json:"name" yaml:"name"`
+	Title    string    `json:"title" yaml:"title"`
+	Status   string    `json:"status" yaml:"status"`
+	Created  time.Time `json:"created" yaml:"created"`
+	Modified time.Time `json:"modified" yaml:"modified"`
+	IdleDays int       `json:"idleDays" yaml:"idleDays"`
+	AgeDays  int       `json:"ageDays" yaml:"ageDays"`
+}
+
+// staleProject holds the stale tasks of an owner in a project, oldest first.
+type staleProject struct {
+	Name  string       `json:"name" yaml:"name"`
+	Count int          `json:"count" yaml:"count"`
+	Tasks []*staleTask `json:"tasks" yaml:"tasks"`
+}
+
+// staleOwner holds the stale tasks of an owner by project, a task with many
+// projects is in each of their groups but only counted once.
+type staleOwner struct {
+	Name     string          `json:"name" yaml:"name"`
+	Count    int             `json:"count" yaml:"count"`
+	Projects []*staleProject `json:"projects" yaml:"projects"`
+
+	projects map[string]*staleProject
+}
+
+// staleReport is the document written by the structured formats.
+type staleReport struct {
+	IdleDays int           `json:"idleDays" yaml:"idleDays"`
+	Total    int           `json:"total" yaml:"total"`
+	Owners   []*staleOwner `json:"owners" yaml:"owners"`
+}
+
+func (pc *phabStaleCommand) Execute(_ []string) error {
+	if pc.taskSelection.empty() {
+		return errNoTasksSelected
+	}
+	client, err := dialPhab(pc.PhabURI, pc.PhabAPIToken)
+	if err != nil {
+		return err
+	}
+	pc.client = client
+
+	tasks, err := pc.selectTasks(pc.baseCommand, pc.client)
+	if err != nil {
+		return err
+	}
+	var stale []*entities.ManiphestTask
+	for _, task := range tasks {
+		if daysSince(pc.now(), task.DateModified) >= pc.IdleDays && daysSince(pc.now(), task.DateCreated) >= pc.MinAgeDays {
+			stale = append(stale, task)
+		}
+	}
+
+	report, err := pc.report(stale)
+	if err != nil {
+		return err
+	}
+	switch pc.Output {
+	case "", outputText:
+		return pc.writeText(report)
+	case outputCSV:
+		return pc.writeCSV(report)
+	}
+	return encodeDocument(pc.Output, pc.output, report)
+}
+
+// report groups the stale tasks by owner and then project with their names looked up.
+func (pc *phabStaleCommand) report(tasks []*entities.ManiphestTask) (*staleReport, error) {
+	var ownerPHIDs, projectPHIDs []string
+	for _, task := range tasks {
+		ownerPHIDs = append(ownerPHIDs, task.OwnerPHID)
+		projectPHIDs = append(projectPHIDs, task.ProjectPHIDs...)
+	}
+	users, err := getPhabUsersByPHID(pc.client, uniqueStrings(ownerPHIDs))
+	if err != nil {
+		return nil, err
+	}
+	projects, err := phabProjectNamesByPHID(pc.client, uniqueStrings(projectPHIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	// oldest activity first, the groups keep this order so the group with the
+	// stalest task comes first
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return time.Time(tasks[i].DateModified).Before(time.Time(tasks[j].DateModified))
+	})
+
+	owners := make(map[string]*staleOwner)
+	report := &staleReport{IdleDays: pc.IdleDays, Total: len(tasks)}
+	for _, task := range tasks {
+		name := noOwner
+		if user, ok := users[task.OwnerPHID]; ok {
+			name = user.UserName
+		}
+		owner, ok := owners[name]
+		if !ok {
+			owner = &staleOwner{Name: name, projects: make(map[string]*staleProject)}
+			owners[name] = owner
+			report.Owners = append(report.Owners, owner)
+		}
+		owner.Count++
+
+		st := &staleTask{
+			Name:     task.ObjectName,
+			Title:    task.Title,
+			Status:   task.Status,
+			Created:  time.Time(task.DateCreated),
+			Modified: time.Time(task.DateModified),
+			IdleDays: daysSince(pc.now(), task.DateModified),
+			AgeDays:  daysSince(pc.now(), task.DateCreated),
+		}
+		var names []string
+		for _, phid := range task.ProjectPHIDs {
+			if name, ok := projects[phid]; ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			names = []string{noProject}
+		}
+		for _, name := range names {
+			project, ok := owner.projects[name]
+			if !ok {
+				project = &staleProject{Name: name}
+				owner.projects[name] = project
+				owner.Projects = append(owner.Projects, project)
+			}
+			project.Tasks = append(project.Tasks, st)
+			project.Count++
+		}
+	}
+	return report, nil
+}
+
+func (pc *phabStaleCommand) writeText(report *staleReport) error {
+	for _, owner := range report.Owners {
+		fmt.Fprintf(pc.output, "Owner: %s (%d stale)\n", owner.Name, owner.Count)
+		for _, project := range owner.Projects {
+			fmt.Fprintf(pc.output, "  Project: %s (%d stale)\n", project.Name, project.Count)
+			for _, t := range project.Tasks {
+				fmt.Fprintf(pc.output, "   - %s: %s [idle %dd, age %dd]\n", t.Name, t.Title, t.IdleDays, t.AgeDays)
+			}
+		}
+	}
+	_, err := fmt.Fprintf(pc.output, "%d tasks idle for %d days or more\n", report.Total, report.IdleDays)
+	return err
+}
+
+func (pc *phabStaleCommand) writeCSV(report *staleReport) error {
+	w := csv.NewWriter(pc.output)
+	w.Write([]string{"owner", "project", "name", "title", "status", "idle_days", "age_days", "modified"})
+	for _, owner := range report.Owners {
+		for _, project := range owner.Projects {
+			for _, t := range project.Tasks {
+				w.Write([]string{
+					owner.Name, project.Name, t.Name, t.Title, t.Status,
+					strconv.Itoa(t.IdleDays), strconv.Itoa(t.AgeDays), t.Modified.Format(time.RFC3339),
+				})
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPhabStale(t *testing.T) {
+	now := time.Date(2018, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) util.UnixTimestamp {
+		return util.UnixTimestamp(now.AddDate(0, 0, -days))
+	}
+
+	tests := []struct {
+		msg        string
+		output     string
+		minAgeDays int
+		want       string
+	}{
+		{
+			msg: "by owner and project",
+			want: "Owner: alice (2 stale)\n" +
+				"  Project: ops (1 stale)\n" +
+				"   - T3: Oldest [idle 90d, age 200d]\n" +
+				"  Project: web (1 stale)\n" +
+				"   - T3: Oldest [idle 90d, age 200d]\n" +
+				"  Project: (no project) (1 stale)\n" +
+				"   - T1: Old [idle 40d, age 50d]\n" +
+				"Owner: (no owner) (1 stale)\n" +
+				"  Project: ops (1 stale)\n" +
+				"   - T4: Orphan [idle 31d, age 31d]\n" +
+				"3 tasks idle for 30 days or more\n",
+		},
+		{
+			msg:        "min age",
+			minAgeDays: 100,
+			want: "Owner: alice (1 stale)\n" +
+				"  Project: ops (1 stale)\n" +
+				"   - T3: Oldest [idle 90d, age 200d]\n" +
+				"  Project: web (1 stale)\n" +
+				"   - T3: Oldest [idle 90d, age 200d]\n" +
+				"1 tasks idle for 30 days or more\n",
+		},
+		{
+			msg:    "csv",
+			output: outputCSV,
+			want: "owner,project,name,title,status,idle_days,age_days,modified\n" +
+				"alice,ops,T3,Oldest,open,90,200,2018-04-01T12:00:00Z\n" +
+				"alice,web,T3,Oldest,open,90,200,2018-04-01T12:00:00Z\n" +
+				"alice,(no project),T1,Old,open,40,50,2018-05-21T12:00:00Z\n" +
+				"(no owner),ops,T4,Orphan,open,31,31,2018-05-30T12:00:00Z\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := newTaskServer(
+				phab.UserQueryResponse{{UserName: "alice", PHID: "PHID-USER-alice"}},
+				[]entities.Project{{Name: "ops", PHID: "PHID-PROJ-ops"}, {Name: "web", PHID: "PHID-PROJ-web"}},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-1", ObjectName: "T1", Title: "Old", Status: "open", OwnerPHID: "PHID-USER-alice",
+					DateCreated: daysAgo(50), DateModified: daysAgo(40),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-2", ObjectName: "T2", Title: "Fresh", Status: "open", OwnerPHID: "PHID-USER-alice",
+					DateCreated: daysAgo(50), DateModified: daysAgo(3),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-3", ObjectName: "T3", Title: "Oldest", Status: "open", OwnerPHID: "PHID-USER-alice",
+					ProjectPHIDs: []string{"PHID-PROJ-ops", "PHID-PROJ-web"}, DateCreated: daysAgo(200), DateModified: daysAgo(90),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-4", ObjectName: "T4", Title: "Orphan", Status: "open",
+					ProjectPHIDs: []string{"PHID-PROJ-ops"}, DateCreated: daysAgo(31), DateModified: daysAgo(31),
+				},
+			)
+			defer s.Close()
+
+			cmd, ok := newPhabStaleCommand(&options{}, zap.NewNop()).(*phabStaleCommand)
+			require.True(t, ok, "conversion to phabStaleCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.now = func() time.Time { return now }
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.Projects = "ops"
+			cmd.IdleDays = 30
+			cmd.MinAgeDays = tt.minAgeDays
+			cmd.Output = tt.output
+
+			require.NoError(t, cmd.Execute(nil /* args */))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+)
+
+// taskGraph caches the tasks fetched during a single run so every task is
+// only queried once, no matter how many other tasks depend on it.
+type taskGraph struct {
+	// tasks by PHID, a nil task was queried but not returned by phab
+	tasks map[string]*entities.ManiphestTask
+	// parents by PHID of the tasks depending on it, filled in when walking up
+	parents map[string][]string
+	// walkedUp tracks the tasks whose parents were already searched for
+	walkedUp map[string]bool
+	// walkedDown tracks the tasks whose dependencies were already fetched,
+	// tasks found walking up are known without them
+	walkedDown map[string]bool
+	// expanded and expandedUp track the tasks already rendered in a tree of
+	// the current section
+	expanded   map[string]bool
+	expandedUp map[string]bool
+}
+
+func newTaskGraph() *taskGraph {
+	return &taskGraph{
+		tasks:      make(map[string]*entities.ManiphestTask),
+		parents:    make(map[string][]string),
+		walkedUp:   make(map[string]bool),
+		walkedDown: make(map[string]bool),
+		expanded:   make(map[string]bool),
+		expandedUp: make(map[string]bool),
+	}
+}
+
+func (g *taskGraph) add(task *entities.ManiphestTask) {
+	g.tasks[task.PHID] = task
+}
+
+// queried marks the tasks as fetched, any not returned by phab stay nil.
+func (g *taskGraph) queried(phids []string) {
+	for _, phid := range phids {
+		if _, ok := g.tasks[phid]; !ok {
+			g.tasks[phid] = nil
+		}
+	}
+}
+
+// newSection forgets the tasks rendered so far so every section of the output
+// writes out a shared subtree in full the first time it shows up.
+func (g *taskGraph) newSection() {
+	g.expanded = make(map[string]bool)
+	g.expandedUp = make(map[string]bool)
+}
+
+// missing returns the dependencies of the tasks that have not been queried yet.
+func (g *taskGraph) missing(tasks []*entities.ManiphestTask) []string {
+	var phids []string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		for _, phid := range task.DependsOnTaskPHIDs {
+			if _, ok := g.tasks[phid]; ok || seen[phid] {
+				continue
+			}
+			seen[phid] = true
+			phids = append(phids, phid)
+		}
+	}
+	return phids
+}
+
+// linkParents records the tasks as parents of every task they depend on.
+func (g *taskGraph) linkParents(parents []*entities.ManiphestTask) {
+	for _, parent := range parents {
+		for _, phid := range parent.DependsOnTaskPHIDs {
+			if !containsString(g.parents[phid], parent.PHID) {
+				g.parents[phid] = append(g.parents[phid], parent.PHID)
+			}
+		}
+	}
+}
+
+// dependencies returns the fetched dependencies of a task sorted by ID.
+func (g *taskGraph) dependencies(task *entities.ManiphestTask) []*entities.ManiphestTask {
+	return g.lookup(task.DependsOnTaskPHIDs)
+}
+
+// dependants returns the fetched tasks depending on a task sorted by ID.
+func (g *taskGraph) dependants(task *entities.ManiphestTask) []*entities.ManiphestTask {
+	return g.lookup(g.parents[task.PHID])
+}
+
+func (g *taskGraph) lookup(phids []string) []*entities.ManiphestTask {
+	var tasks []*entities.ManiphestTask
+	for _, phid := range phids {
+		if task := g.tasks[phid]; task != nil {
+			tasks = append(tasks, task)
+		}
+	}
+	sortTasks(tasks)
+	return tasks
+}
+
+// tree builds the dependency tree of a task from the cache. Tasks are
+// expanded in the same order they are rendered so only the first occurrence
+// of a shared subtree is written out in full.
+func (g *taskGraph) tree(task *entities.ManiphestTask) *phab.TaskTree {
+	return g.build(task, g.dependencies, g.expanded, make(map[string]bool))
+}
+
+// reverseTree builds the tree of tasks depending on the task up to the root
+// tasks nothing depends on.
+func (g *taskGraph) reverseTree(task *entities.ManiphestTask) *phab.TaskTree {
+	node := g.build(task, g.dependants, g.expandedUp, make(map[string]bool))
+	node.Reverse = true
+	return node
+}
+
+func (g *taskGraph) build(
+	task *entities.ManiphestTask,
+	edges func(*entities.ManiphestTask) []*entities.ManiphestTask,
+	expanded map[string]bool,
+	ancestors map[string]bool,
+) *phab.TaskTree {
+	node := &phab.TaskTree{ManiphestTask: task}
+	if ancestors[task.PHID] {
+		node.Cycle = true
+		return node
+	}
+	items := edges(task)
+	if len(items) == 0 {
+		return node
+	}
+	if expanded[task.PHID] {
+		node.Shared = true
+		return node
+	}
+	expanded[task.PHID] = true
+
+	ancestors[task.PHID] = true
+	for _, item := range items {
+		node.Items = append(node.Items, g.build(item, edges, expanded, ancestors))
+	}
+	delete(ancestors, task.PHID)
+	return node
+}
+
+func sortTasks(tasks []*entities.ManiphestTask) {
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/requests"
+	"github.com/etcinit/gonduit/util"
+	"github.com/pkg/errors"
+)
+
+var errNoTasksSelected = errors.New("select the tasks with --tasks, --projects or --task_author")
+
+// taskSelection are the flags of the commands working on a set of existing tasks.
+type taskSelection struct {
+	TasksByOwner string `long:"task_author" description:"Comma sep list of usernames to select all owned tasks of"`
+	Tasks        string `long:"tasks" description:"Comma sep List of tasks to select"`
+	Projects     string `long:"projects" description:"Comma sep list of projects to select all tasks of"`
+	Status       string `long:"status" description:"Only select tasks with the status" default:"open" choice:"open" choice:"closed" choice:"resolved" choice:"wontfix" choice:"invalid" choice:"any"`
+}
+
+// empty is true when no tasks are selected at all.
+func (ts taskSelection) empty() bool {
+	return ts.Tasks == "" && ts.Projects == "" && ts.TasksByOwner == ""
+}
+
+// mixed is true when more than one of the tasks, projects and owners flags is
+// set, their tasks are added together.
+func (ts taskSelection) mixed() bool {
+	set := 0
+	for _, flag := range []string{ts.Tasks, ts.Projects, ts.TasksByOwner} {
+		if flag != "" {
+			set++
+		}
+	}
+	return set > 1
+}
+
+// selectTasks returns the tasks matched by the tasks, projects and owners
+// flags the same way the phab command does, without duplicates.
+func (ts taskSelection) selectTasks(base baseCommand, client *gonduit.Conn) ([]*entities.ManiphestTask, error) {
+	lister := &phabCommand{baseCommand: base, client: client, Status: ts.Status, graph: newTaskGraph()}
+	status := lister.queryStatus()
+	var queries []requests.ManiphestQueryRequest
+
+	if ts.Projects != "" {
+		names := splitList(ts.Projects)
+		projects, err := phabProjectLookup(client, names)
+		if err != nil {
+			return nil, err
+		}
+		if err := compareProjects(names, projects); err != nil {
+			return nil, err
+		}
+		req := requests.ManiphestQueryRequest{Status: status}
+		for _, project := range projects {
+			req.ProjectPHIDs = append(req.ProjectPHIDs, project.PHID)
+		}
+		queries = append(queries, req)
+	}
+
+	if ts.TasksByOwner != "" {
+		users, err := getPhabUsers(client, splitList(ts.TasksByOwner))
+		if err != nil {
+			return nil, err
+		}
+		req := requests.ManiphestQueryRequest{Status: status}
+		for _, user := range users {
+			req.OwnerPHIDs = append(req.OwnerPHIDs, user.PHID)
+		}
+		queries = append(queries, req)
+	}
+
+	if ts.Tasks != "" {
+		phids, err := lister.phabLookupPHIDByName(splitList(ts.Tasks))
+		if err != nil {
+			return nil, err
+		}
+		req := requests.ManiphestQueryRequest{Status: status}
+		for _, result := range phids {
+			req.PHIDs = append(req.PHIDs, result.PHID)
+		}
+		queries = append(queries, req)
+	}
+
+	seen := make(map[string]bool)
+	var tasks []*entities.ManiphestTask
+	for _, req := range queries {
+		roots, err := lister.phabManiphestQueryRoots(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range roots {
+			if !seen[task.PHID] {
+				seen[task.PHID] = true
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	sortTasks(tasks)
+	return tasks, nil
+}
+
+// splitList splits a comma sep flag, an empty flag is an empty list.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// daysSince returns the full days between the timestamp and now.
+func daysSince(now time.Time, t util.UnixTimestamp) int {
+	return int(now.Sub(time.Time(t)) / (24 * time.Hour))
+}
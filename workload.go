@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etcinit/gonduit"
+	"github.com/etcinit/gonduit/entities"
+	"go.uber.org/zap"
+)
+
+// workloadPriorities are the maniphest priorities from the most to the least
+// urgent, they are always shown so tables line up between runs.
+var workloadPriorities = []string{"Unbreak Now!", "Needs Triage", "High", "Normal", "Low", "Wishlist"}
+
+type phabWorkloadCommand struct {
+	baseCommand
+
+	PhabURI      string `long:"phab-uri" description:"The base phab uri" default:"https://phab.example.com"`
+	PhabAPIToken string `long:"api-token" description:"The phab api token to connect with, https://phab.example.com/settings/user/<user>/page/apitokens/"`
+
+	taskSelection
+
+	Output string `long:"output" short:"o" description:"The format to write results in" default:"text" choice:"text" choice:"json" choice:"yaml" choice:"csv"`
+
+	output io.Writer
+	// The phab conduit client for the command to share the client session
+	client *gonduit.Conn
+	// now is the time the age of the oldest task is counted from
+	now func() time.Time
+}
+
+func newPhabWorkloadCommand(opts *options, logger *zap.Logger) command {
+	return &phabWorkloadCommand{
+		baseCommand: newBaseCommand(
+			"phab-workload",
+			"Summarize the tasks of every owner.",
+			"Count the tasks of a set of projects or users by owner and priority to see who is overloaded.",
+			opts, logger),
+		output: os.Stdout,
+		now:    time.Now,
+	}
+}
+
+// workloadOwner is a row of the workload table.
+type workloadOwner struct {
+	Owner      string         `json:"owner" yaml:"owner"`
+	Total      int            `json:"total" yaml:"total"`
+	Priorities map[string]int `json:"priorities" yaml:"priorities"`
+	Oldest     string         `json:"oldest" yaml:"oldest"`
+	OldestDays int            `json:"oldestDays" yaml:"oldestDays"`
+
+	oldest time.Time
+}
+
+// workloadReport is the document written by the structured formats.
+type workloadReport struct {
+	Priorities []string         `json:"priorities" yaml:"priorities"`
+	Owners     []*workloadOwner `json:"owners" yaml:"owners"`
+	Unassigned int              `json:"unassigned" yaml:"unassigned"`
+}
+
+func (pc *phabWorkloadCommand) Execute(_ []string) error {
+	if pc.taskSelection.empty() {
+		return errNoTasksSelected
+	}
+	client, err := dialPhab(pc.PhabURI, pc.PhabAPIToken)
+	if err != nil {
+		return err
+	}
+	pc.client = client
+
+	tasks, err := pc.workloadTasks()
+	if err != nil {
+		return err
+	}
+	report, err := pc.report(tasks)
+	if err != nil {
+		return err
+	}
+	switch pc.Output {
+	case "", outputText:
+		return pc.writeText(report)
+	case outputCSV:
+		return pc.writeCSV(report)
+	}
+	return encodeDocument(pc.Output, pc.output, report)
+}
+
+// workloadTasks returns the selected tasks. Unlike the other task commands a
+// task only has to be in one of the projects to be counted, each project is
+// queried on its own.
+func (pc *phabWorkloadCommand) workloadTasks() ([]*entities.ManiphestTask, error) {
+	selections := []taskSelection{pc.taskSelection}
+	selections[0].Projects = ""
+	if selections[0].empty() {
+		selections = nil
+	}
+	for _, project := range splitList(pc.Projects) {
+		selections = append(selections, taskSelection{Projects: project, Status: pc.Status})
+	}
+
+	seen := make(map[string]bool)
+	var tasks []*entities.ManiphestTask
+	for _, selection := range selections {
+		selected, err := selection.selectTasks(pc.baseCommand, pc.client)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range selected {
+			if !seen[task.PHID] {
+				seen[task.PHID] = true
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	sortTasks(tasks)
+	return tasks, nil
+}
+
+// report counts the tasks by owner and priority, owners with the most tasks first.
+func (pc *phabWorkloadCommand) report(tasks []*entities.ManiphestTask) (*workloadReport, error) {
+	var ownerPHIDs []string
+	for _, task := range tasks {
+		ownerPHIDs = append(ownerPHIDs, task.OwnerPHID)
+	}
+	users, err := getPhabUsersByPHID(pc.client, uniqueStrings(ownerPHIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &workloadReport{Priorities: append([]string{}, workloadPriorities...)}
+	var extra []string
+	owners := make(map[string]*workloadOwner)
+	for _, task := range tasks {
+		if task.OwnerPHID == "" {
+			report.Unassigned++
+			continue
+		}
+		// owners phab does not know about anymore are still shown by PHID
+		name := task.OwnerPHID
+		if user, ok := users[task.OwnerPHID]; ok {
+			name = user.UserName
+		}
+		owner, ok := owners[name]
+		if !ok {
+			owner = &workloadOwner{Owner: name, Priorities: make(map[string]int)}
+			owners[name] = owner
+			report.Owners = append(report.Owners, owner)
+		}
+
+		owner.Total++
+		owner.Priorities[task.Priority]++
+		if !containsString(report.Priorities, task.Priority) && !containsString(extra, task.Priority) {
+			extra = append(extra, task.Priority)
+		}
+		if created := time.Time(task.DateCreated); owner.Oldest == "" || created.Before(owner.oldest) {
+			owner.oldest = created
+			owner.Oldest = task.ObjectName
+			owner.OldestDays = daysSince(pc.now(), task.DateCreated)
+		}
+	}
+	sort.Strings(extra)
+	report.Priorities = append(report.Priorities, extra...)
+
+	sort.Slice(report.Owners, func(i, j int) bool {
+		if report.Owners[i].Total != report.Owners[j].Total {
+			return report.Owners[i].Total > report.Owners[j].Total
+		}
+		return report.Owners[i].Owner < report.Owners[j].Owner
+	})
+	return report, nil
+}
+
+func (pc *phabWorkloadCommand) writeText(report *workloadReport) error {
+	w := tabwriter.NewWriter(pc.output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "OWNER\tTOTAL\t%s\tOLDEST\n", strings.ToUpper(strings.Join(report.Priorities, "\t")))
+	for _, owner := range report.Owners {
+		fmt.Fprintf(w, "%s\t%d\t", owner.Owner, owner.Total)
+		for _, priority := range report.Priorities {
+			fmt.Fprintf(w, "%d\t", owner.Priorities[priority])
+		}
+		fmt.Fprintf(w, "%s (%dd)\n", owner.Oldest, owner.OldestDays)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(pc.output, "Unassigned: %d\n", report.Unassigned)
+	return err
+}
+
+func (pc *phabWorkloadCommand) writeCSV(report *workloadReport) error {
+	w := csv.NewWriter(pc.output)
+	header := append([]string{"owner", "total"}, report.Priorities...)
+	w.Write(append(header, "oldest", "oldest_days"))
+	for _, owner := range report.Owners {
+		row := []string{owner.Owner, strconv.Itoa(owner.Total)}
+		for _, priority := range report.Priorities {
+			row = append(row, strconv.Itoa(owner.Priorities[priority]))
+		}
+		w.Write(append(row, owner.Oldest, strconv.Itoa(owner.OldestDays)))
+	}
+	// unassigned tasks only get counted, the rest of their row stays empty
+	unassigned := make([]string, len(header)+2)
+	unassigned[0], unassigned[1] = noOwner, strconv.Itoa(report.Unassigned)
+	w.Write(unassigned)
+	w.Flush()
+	return w.Error()
+}
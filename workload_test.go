@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffbean/inam/phab"
+
+	"github.com/etcinit/gonduit/entities"
+	"github.com/etcinit/gonduit/responses"
+	"github.com/etcinit/gonduit/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPhabWorkload(t *testing.T) {
+	now := time.Date(2018, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) util.UnixTimestamp {
+		return util.UnixTimestamp(now.AddDate(0, 0, -days))
+	}
+
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{
+			output: outputText,
+			want: "OWNER  TOTAL  UNBREAK NOW!  NEEDS TRIAGE  HIGH  NORMAL  LOW  WISHLIST  OLDEST\n" +
+				"alice  2      0             0             1     1       0    0         T3 (200d)\n" +
+				"bob    1      0             0             0     0       1    0         T2 (5d)\n" +
+				"Unassigned: 1\n",
+		},
+		{
+			output: outputCSV,
+			want: "owner,total,Unbreak Now!,Needs Triage,High,Normal,Low,Wishlist,oldest,oldest_days\n" +
+				"alice,2,0,0,1,1,0,0,T3,200\n" +
+				"bob,1,0,0,0,0,1,0,T2,5\n" +
+				"(no owner),1,,,,,,,,\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			s := newTaskServer(
+				phab.UserQueryResponse{
+					{UserName: "alice", PHID: "PHID-USER-alice"},
+					{UserName: "bob", PHID: "PHID-USER-bob"},
+				},
+				[]entities.Project{{Name: "ops", PHID: "PHID-PROJ-ops"}},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-1", ObjectName: "T1", Priority: "High", OwnerPHID: "PHID-USER-alice",
+					DateCreated: daysAgo(10),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-2", ObjectName: "T2", Priority: "Low", OwnerPHID: "PHID-USER-bob",
+					DateCreated: daysAgo(5),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-3", ObjectName: "T3", Priority: "Normal", OwnerPHID: "PHID-USER-alice",
+					DateCreated: daysAgo(200),
+				},
+				&entities.ManiphestTask{
+					PHID: "PHID-TASK-4", ObjectName: "T4", Priority: "High",
+					DateCreated: daysAgo(300),
+				},
+			)
+			defer s.Close()
+
+			cmd, ok := newPhabWorkloadCommand(&options{}, zap.NewNop()).(*phabWorkloadCommand)
+			require.True(t, ok, "conversion to phabWorkloadCommand failed")
+			var out bytes.Buffer
+			cmd.output = &out
+			cmd.now = func() time.Time { return now }
+			cmd.PhabURI = s.GetURL()
+			cmd.PhabAPIToken = "some-token"
+			cmd.Projects = "ops"
+			cmd.Output = tt.output
+
+			require.NoError(t, cmd.Execute(nil /* args */))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestPhabWorkloadProjects(t *testing.T) {
+	projects := map[string]entities.Project{
+		"ops": {Name: "ops", PHID: "PHID-PROJ-ops"},
+		"web": {Name: "web", PHID: "PHID-PROJ-web"},
+	}
+	tasks := []*entities.ManiphestTask{
+		{PHID: "PHID-TASK-1", ObjectName: "T1", Priority: "High", OwnerPHID: "PHID-USER-alice", ProjectPHIDs: []string{"PHID-PROJ-ops"}},
+		{PHID: "PHID-TASK-2", ObjectName: "T2", Priority: "Low", OwnerPHID: "PHID-USER-bob", ProjectPHIDs: []string{"PHID-PROJ-web"}},
+		{PHID: "PHID-TASK-3", ObjectName: "T3", Priority: "High", OwnerPHID: "PHID-USER-alice", ProjectPHIDs: []string{"PHID-PROJ-ops", "PHID-PROJ-web"}},
+	}
+	s := newTaskServer(phab.UserQueryResponse{
+		{UserName: "alice", PHID: "PHID-USER-alice"},
+		{UserName: "bob", PHID: "PHID-USER-bob"},
+	}, nil /* projects */, tasks...)
+	defer s.Close()
+
+	rs := newRecordingServer(t, s)
+	defer rs.Close()
+	// phab only answers with the projects asked for and the tasks in all of them
+	rs.answer = func(method string, params json.RawMessage) (map[string]interface{}, bool) {
+		var req struct {
+			Names        []string `json:"names"`
+			ProjectPHIDs []string `json:"projectPHIDs"`
+		}
+		require.NoError(t, json.Unmarshal(params, &req))
+		switch method {
+		case "project.query":
+			data := make(map[string]entities.Project)
+			for _, name := range req.Names {
+				data[projects[name].PHID] = projects[name]
+			}
+			return map[string]interface{}{"result": responses.ProjectQueryResponse{Data: data}}, true
+		case "maniphest.query":
+			res := make(responses.ManiphestQueryResponse)
+			for _, task := range tasks {
+				inAll := true
+				for _, phid := range req.ProjectPHIDs {
+					inAll = inAll && containsString(task.ProjectPHIDs, phid)
+				}
+				if inAll {
+					res[task.PHID] = task
+				}
+			}
+			return map[string]interface{}{"result": res}, true
+		}
+		return nil, false
+	}
+
+	cmd, ok := newPhabWorkloadCommand(&options{}, zap.NewNop()).(*phabWorkloadCommand)
+	require.True(t, ok, "conversion to phabWorkloadCommand failed")
+	var out bytes.Buffer
+	cmd.output = &out
+	cmd.PhabURI = rs.URL
+	cmd.PhabAPIToken = "some-token"
+	cmd.Projects = "ops,web"
+	cmd.Output = outputCSV
+
+	require.NoError(t, cmd.Execute(nil /* args */))
+	rows := strings.Split(out.String(), "\n")
+	require.Len(t, rows, 5)
+	assert.True(t, strings.HasPrefix(rows[1], "alice,2,0,0,2,0,0,0,"), "T3 is only counted once: %q", rows[1])
+	assert.True(t, strings.HasPrefix(rows[2], "bob,1,0,0,0,0,1,0,"), "tasks of any project are counted: %q", rows[2])
+
+	for _, params := range rs.params("maniphest.query") {
+		var req struct {
+			ProjectPHIDs []string `json:"projectPHIDs"`
+		}
+		require.NoError(t, json.Unmarshal(params, &req))
+		assert.Len(t, req.ProjectPHIDs, 1, "every project is queried on its own")
+	}
+}